@@ -0,0 +1,229 @@
+package gowalker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// Delete removes the value at the path described by expr from data. Map keys are removed, array items are removed
+// from the slice holding them, and struct fields are set to their zero value. Wildcards and filters remove, or remove
+// from, all the items they select, as in `users[*].password`. Deleting a path that does not exist, such as a missing
+// key or an index beyond the array, does nothing. Maps are modified in place, while structs and slices have to be
+// passed as a pointer
+func Delete(ctx context.Context, expr string, data any) error {
+	expression, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+	return expression.Delete(ctx, data)
+}
+
+// Delete removes the value at the path described by the expression from data. See Delete
+func (e *Expression) Delete(ctx context.Context, data any) error {
+	if len(e.steps) == 0 {
+		return errors.New("cannot delete the whole data")
+	}
+	d := deleter{ctx: withoutOutput(ctx), scope: data, functions: NewFunctions()}
+	err := modify(data, func(target reflect.Value) (reflect.Value, error) {
+		return d.delete(e.steps, target)
+	})
+	return withExpr(err, e.source)
+}
+
+// deleter holds the state of a Delete operation
+// scope is the data Delete was invoked with, against which paths in square brackets and filters are evaluated
+type deleter struct {
+	ctx       context.Context
+	scope     any
+	functions *Functions
+}
+
+// delete returns current, with the value at steps removed. As current may not be addressable, and slices are
+// reallocated, the caller has to store the result in place of current
+func (d deleter) delete(steps []step, current reflect.Value) (reflect.Value, error) {
+	if err := checkContext(d.ctx); err != nil {
+		return current, err
+	}
+	st := steps[0]
+	last := len(steps) == 1
+	switch current.Kind() {
+	case reflect.Interface:
+		if current.IsNil() {
+			return current, nil
+		}
+		return d.delete(steps, copyValue(current.Elem()))
+	case reflect.Pointer:
+		if current.IsNil() {
+			return current, nil
+		}
+		res, err := d.delete(steps, current.Elem())
+		if err != nil {
+			return current, err
+		}
+		current.Elem().Set(res)
+		return current, nil
+	}
+	if st.kind == wildcardStep || st.kind == filterStep {
+		return d.deleteAll(steps, current)
+	}
+	key, err := stepKey(d.ctx, st, d.scope, d.functions)
+	if err != nil {
+		return current, stepError(st, err)
+	}
+	switch current.Kind() {
+	case reflect.Map:
+		k, ok := convertKey(key, current.Type().Key())
+		if !ok || current.IsNil() {
+			return current, nil
+		}
+		if last {
+			current.SetMapIndex(k, reflect.Value{})
+			return current, nil
+		}
+		item := current.MapIndex(k)
+		if !item.IsValid() {
+			return current, nil
+		}
+		res, err := d.delete(steps[1:], copyValue(item))
+		if err != nil {
+			return current, err
+		}
+		current.SetMapIndex(k, res)
+		return current, nil
+	case reflect.Slice, reflect.Array:
+		index, ok := key.(int)
+		if !ok {
+			return current, stepError(st, keyError(st, key, "array index is not an integer"))
+		}
+		if index < 0 {
+			index += current.Len()
+		}
+		if index < 0 || index >= current.Len() {
+			// the index does not exist, so there's nothing to delete
+			return current, nil
+		}
+		selected := make([]bool, current.Len())
+		selected[index] = true
+		return d.deleteItems(steps, current, selected)
+	case reflect.Struct:
+		name, ok := key.(string)
+		if !ok {
+			return current, stepError(st, keyError(st, key, "field name is not a string"))
+		}
+		field, found := findField(current.Type(), name, d.functions.jsonTags)
+		if !found {
+			return current, stepError(st, ErrPrivateField)
+		}
+		current = copyValue(current)
+		target, err := current.FieldByIndexErr(field.index)
+		if err != nil {
+			// the field is promoted from a nil embedded struct, so there's nothing to delete
+			return current, nil
+		}
+		if last {
+			target.Set(reflect.Zero(target.Type()))
+			return current, nil
+		}
+		res, err := d.delete(steps[1:], copyValue(target))
+		if err != nil {
+			return current, err
+		}
+		target.Set(res)
+		return current, nil
+	}
+	// anything else does not contain what we're looking for
+	return current, nil
+}
+
+// deleteAll applies a wildcard or a filter: the items it selects are removed, if it's the last step, or the
+// remaining steps are deleted from each of them
+func (d deleter) deleteAll(steps []step, current reflect.Value) (reflect.Value, error) {
+	st := steps[0]
+	switch current.Kind() {
+	case reflect.Slice, reflect.Array:
+		selected := make([]bool, current.Len())
+		for i := range selected {
+			selected[i] = st.kind == wildcardStep
+			if st.kind == filterStep {
+				val, err := st.filter.eval(d.ctx, current.Index(i).Interface(), d.scope, d.functions)
+				if err != nil {
+					return current, stepError(st, err)
+				}
+				selected[i] = isTruthy(val)
+			}
+		}
+		return d.deleteItems(steps, current, selected)
+	case reflect.Map:
+		if st.kind == filterStep || current.IsNil() {
+			// only arrays can be filtered
+			return current, nil
+		}
+		for _, key := range sortedKeys(current) {
+			if len(steps) == 1 {
+				current.SetMapIndex(key, reflect.Value{})
+				continue
+			}
+			res, err := d.delete(steps[1:], copyValue(current.MapIndex(key)))
+			if err != nil {
+				return current, err
+			}
+			current.SetMapIndex(key, res)
+		}
+		return current, nil
+	case reflect.Struct:
+		if st.kind == filterStep {
+			return current, nil
+		}
+		current = copyValue(current)
+		for _, field := range structFields(current.Type(), d.functions.jsonTags) {
+			target, err := current.FieldByIndexErr(field.index)
+			if err != nil {
+				continue
+			}
+			if len(steps) == 1 {
+				target.Set(reflect.Zero(target.Type()))
+				continue
+			}
+			res, err := d.delete(steps[1:], copyValue(target))
+			if err != nil {
+				return current, err
+			}
+			target.Set(res)
+		}
+		return current, nil
+	}
+	return current, nil
+}
+
+// deleteItems applies the steps to the selected items of an array. If it's the last step, the items are removed from
+// a new slice, or set to their zero value in Go arrays, that cannot shrink. Otherwise, the remaining steps are deleted
+// from each of them
+func (d deleter) deleteItems(steps []step, current reflect.Value, selected []bool) (reflect.Value, error) {
+	if len(steps) == 1 && current.Kind() == reflect.Slice {
+		res := reflect.MakeSlice(current.Type(), 0, current.Len())
+		for i := 0; i < current.Len(); i++ {
+			if !selected[i] {
+				res = reflect.Append(res, current.Index(i))
+			}
+		}
+		return res, nil
+	}
+	current = copyValue(current)
+	for i := 0; i < current.Len(); i++ {
+		if !selected[i] {
+			continue
+		}
+		target := current.Index(i)
+		if len(steps) == 1 {
+			target.Set(reflect.Zero(target.Type()))
+			continue
+		}
+		res, err := d.delete(steps[1:], copyValue(target))
+		if err != nil {
+			return current, err
+		}
+		target.Set(res)
+	}
+	return current, nil
+}
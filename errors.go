@@ -0,0 +1,107 @@
+package gowalker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrIndexOutOfBounds is returned when an index exceeds the array
+	ErrIndexOutOfBounds = errors.New("index out of bounds")
+	// ErrPrivateField is returned when selecting a struct field that is not exported, or that does not exist
+	ErrPrivateField = errors.New("cannot access private field")
+	// ErrArrayAttribute is returned when selecting a member by name in an array
+	ErrArrayAttribute = errors.New("cannot access attributes from an array")
+	// ErrFunctionNotFound is returned when invoking a function that is not registered
+	ErrFunctionNotFound = errors.New("function not found")
+	// ErrTemplateNotFound is returned when rendering a sub-template that does not exist
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrNotIterable is returned when iterating on data that is not an array, a map, a struct or a Walkable
+	ErrNotIterable = errors.New("cannot iterate on a data type that is not an array")
+	// ErrMissingParameter is returned when a function is invoked without a parameter it requires, such as the name of
+	// the sub-template in `render`
+	ErrMissingParameter = errors.New("missing parameter")
+	// ErrUnsupportedType is returned when a function is invoked against data of a type it does not support, such as
+	// `split` against a number
+	ErrUnsupportedType = errors.New("unsupported data type")
+	// ErrNotAddressable is returned by Set and Delete when the data cannot be modified in place
+	ErrNotAddressable = errors.New("data is not addressable")
+	// ErrPathNotFound is returned by Set when a container in the path is missing, or when the path selects a member
+	// of a value that has none, such as a string
+	ErrPathNotFound = errors.New("the path does not exist")
+	// ErrNilContainer is returned by Set when a container in the path is a nil map or a nil pointer
+	ErrNilContainer = errors.New("the container is nil")
+	// ErrMultipleValues is returned by Set when the path selects multiple values, as with wildcards and filters
+	ErrMultipleValues = errors.New("the path does not select a single value")
+	// ErrDeadlineExceeded is returned when the deadline of the context is met. It wraps context.DeadlineExceeded
+	ErrDeadlineExceeded error = &contextError{msg: "deadline exceeded", cause: context.DeadlineExceeded}
+	// ErrCancelled is returned when the context is cancelled. It wraps context.Canceled
+	ErrCancelled error = &contextError{msg: "cancelled", cause: context.Canceled}
+)
+
+// contextError is an error caused by the context
+// msg is the message of the error
+// cause is the error of the context it wraps
+type contextError struct {
+	msg   string
+	cause error
+}
+
+// Error returns the message of the context error
+func (e *contextError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the error of the context
+func (e *contextError) Unwrap() error {
+	return e.cause
+}
+
+// PathError is returned when evaluating an expression fails at one of its steps
+// Expr is the whole expression. In templates, it's the expression of the marker or block that failed
+// Segment is the portion of the expression that failed, as in `[5]` or `size()`
+// Offset is the 0-based position of the segment in Expr
+// Err is the cause, such as ErrIndexOutOfBounds, a *KeyError or the error returned by a function
+type PathError struct {
+	Expr    string
+	Segment string
+	Offset  int
+	Err     error
+}
+
+// Error returns a textual representation of the path error
+func (e *PathError) Error() string {
+	if e.Expr == "" {
+		return fmt.Sprintf("%s at column %d: %s", e.Segment, e.Offset+1, e.Err)
+	}
+	return fmt.Sprintf("%s at column %d of %s: %s", e.Segment, e.Offset+1, e.Expr, e.Err)
+}
+
+// Unwrap returns the cause of the path error
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// stepError wraps err, that occurred applying the step st, in a *PathError. The errors of the context, and the errors
+// already wrapped, as the ones of nested paths, are returned as they are
+func stepError(st step, err error) error {
+	var pathErr *PathError
+	if err == nil || errors.As(err, &pathErr) {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &PathError{Segment: st.source, Offset: st.offset, Err: err}
+}
+
+// withExpr sets expr as the expression of a *PathError that does not have one yet, as the walker does not know the
+// source of the steps it walks
+func withExpr(err error, expr string) error {
+	var pathErr *PathError
+	if errors.As(err, &pathErr) && pathErr.Expr == "" {
+		pathErr.Expr = expr
+	}
+	return err
+}
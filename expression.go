@@ -0,0 +1,756 @@
+package gowalker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expression is a compiled path expression. Compiling an expression once and evaluating it many times avoids
+// re-parsing the path at every call. An Expression is immutable, therefore it can be evaluated concurrently
+type Expression struct {
+	source string
+	steps  []step
+}
+
+// stepKind is the type of operation a step performs against the data
+type stepKind int
+
+const (
+	// fieldStep selects a member of a map or a struct
+	fieldStep stepKind = iota
+	// indexStep selects an item of an array
+	indexStep
+	// callStep invokes a function against the data
+	callStep
+	// rootStep selects the scope of the outermost template being rendered
+	rootStep
+	// parentStep selects the scope of the enclosing template
+	parentStep
+	// wildcardStep selects all the items of an array, or all the values of a map or struct, and applies the
+	// following steps to each of them
+	wildcardStep
+	// sliceStep selects a range of items of an array
+	sliceStep
+	// filterStep selects the items of an array matching a predicate, and applies the following steps to each of them
+	filterStep
+	// descentStep selects the members with a given name at any depth, and applies the following steps to each of them
+	descentStep
+	// keyStep selects an item of an array, or a member of a map or struct, by the value of a path
+	keyStep
+)
+
+const (
+	// rootScope is the name of the scope of the outermost template being rendered
+	rootScope = "$root"
+	// parentScope is the name of the scope of the enclosing template
+	parentScope = "$parent"
+)
+
+// step is one node of a compiled expression
+// kind is the operation the step performs
+// name is the field or function name
+// index is the array index, for index steps. Negative indexes count from the end of the array
+// bounds are the bounds of the range, for slice steps
+// filter is the predicate items have to match, for filter steps
+// path is the expression whose value is the index or the key, for key steps
+// params are the parameters of a function call
+// pipe is true if the step is a pipe stage, which applies to the result of a projection as a whole
+// source is the portion of the expression the step was parsed from
+// offset is the 0-based position of source in the expression
+type step struct {
+	kind   stepKind
+	name   string
+	index  int
+	bounds sliceBounds
+	filter *predicate
+	path   *Expression
+	params []param
+	pipe   bool
+	source string
+	offset int
+}
+
+// sliceBounds are the bounds of a range of items, as in `[start:end:step]`. Omitted bounds are nil
+type sliceBounds struct {
+	start *int
+	end   *int
+	step  *int
+}
+
+// param is a parameter of a function call
+// value is the typed value of the parameter: a string, an int, a float64, a bool or nil
+// text is the value of the parameter as passed to the functions accepting strings
+// path is the compiled expression of an unquoted parameter that looks like a path, nil otherwise
+type param struct {
+	value any
+	text  string
+	path  *Expression
+}
+
+// resolve returns the value of the parameter. Parameters with a path are evaluated against the scope of the
+// expression when `paths` is true, or if they start with a dollar sign, as in `$root.separator`
+func (p param) resolve(ctx context.Context, scope any, functions *Functions, paths bool) (any, error) {
+	if p.path == nil || !(paths || strings.HasPrefix(p.text, "$")) {
+		return p.value, nil
+	}
+	return walkImpl(withoutOutput(ctx), p.path.steps, scope, scope, functions)
+}
+
+// resolveText returns the value of the parameter as a string. Only parameters starting with a dollar sign are
+// evaluated, and if they resolve to nil, as when the path is missing, they're an empty string
+func (p param) resolveText(ctx context.Context, scope any, functions *Functions) (string, error) {
+	val, err := p.resolve(ctx, scope, functions, false)
+	if err != nil || p.path == nil {
+		return p.text, err
+	}
+	if val == nil {
+		return "", nil
+	}
+	return convertDataToString(ctx, val)
+}
+
+// SyntaxError is returned by Compile when an expression is malformed, and by ParseTemplate when a template is.
+// Line and Column are 1-based. Line is only set for templates, as expressions are single line
+type SyntaxError struct {
+	Expr   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+// Error returns a textual representation of the syntax error
+func (e *SyntaxError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("syntax error at column %d: %s", e.Column, e.Msg)
+}
+
+// Compile parses an expression into an Expression that can be evaluated multiple times
+func Compile(expr string) (*Expression, error) {
+	p := parser{src: expr}
+	steps, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{source: expr, steps: steps}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed. It simplifies the initialization of
+// global variables holding compiled expressions
+func MustCompile(expr string) *Expression {
+	expression, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return expression
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against the provided data, with the provided functions
+func (e *Expression) Eval(ctx context.Context, data any, functions *Functions) (any, error) {
+	if functions == nil {
+		functions = NewFunctions()
+	}
+	// the result is returned to the caller, so functions cannot write it to a template output
+	res, err := walkImpl(withLazyCache(withoutOutput(ctx)), e.steps, data, data, functions)
+	return res, withExpr(err, e.source)
+}
+
+// Lookup evaluates the expression against the provided data, with the provided functions, and tells whether the path
+// exists in the data. See Lookup
+func (e *Expression) Lookup(ctx context.Context, data any, functions *Functions) (WalkResult, error) {
+	if functions == nil {
+		functions = NewFunctions()
+	}
+	ctx = withLazyCache(withoutOutput(ctx))
+	if !e.selectsMember() {
+		// anything but a selector, such as a function, produces its value itself
+		val, err := walkImpl(ctx, e.steps, data, data, functions)
+		if err != nil {
+			return WalkResult{Value: val}, withExpr(err, e.source)
+		}
+		return WalkResult{Value: val, Found: true}, nil
+	}
+	// the path is walked up to the container of the last step, which is checked for the member before selecting it,
+	// so that a missing member is not found rather than an error
+	last := e.steps[len(e.steps)-1]
+	parent, err := walkImpl(ctx, e.steps[:len(e.steps)-1], data, data, functions)
+	if err != nil {
+		return WalkResult{}, withExpr(err, e.source)
+	}
+	found, err := hasMember(ctx, last, parent, data, functions)
+	if err != nil || !found {
+		return WalkResult{}, withExpr(stepError(last, err), e.source)
+	}
+	val, err := walkImpl(ctx, e.steps[len(e.steps)-1:], parent, data, functions)
+	if err != nil {
+		return WalkResult{Value: val}, withExpr(err, e.source)
+	}
+	return WalkResult{Value: val, Found: true}, nil
+}
+
+// selectsMember tells whether the last step of the expression selects a member of the value the previous steps
+// resolve to. That's not the case if a previous step is a projection, as the last step applies to each of its items
+func (e *Expression) selectsMember() bool {
+	if len(e.steps) == 0 {
+		return false
+	}
+	for _, st := range e.steps {
+		if st.kind == wildcardStep || st.kind == filterStep || st.kind == descentStep {
+			return false
+		}
+	}
+	last := e.steps[len(e.steps)-1]
+	return last.kind == fieldStep || last.kind == keyStep || last.kind == indexStep
+}
+
+// parser turns the source of an expression into a list of steps
+type parser struct {
+	src string
+	pos int
+}
+
+// parse parses the whole source. Leading and trailing dots are admitted, pretty much like Go templates work, and a
+// lone dot refers to the whole scope. A double dot, as in `..id`, selects a name at any depth. A pipe passes the value
+// on its left to the function on its right, as in `name | toString`
+func (p *parser) parse() ([]step, error) {
+	steps := make([]step, 0)
+	p.skipSpaces()
+	leadingDot := p.peek() == '.' && !strings.HasPrefix(p.src[p.pos:], "..")
+	if leadingDot {
+		p.pos++
+	}
+	// expectName is true when the parser just consumed a dot and needs a name to follow
+	expectName := false
+	for p.skipSpaces(); !p.eof(); p.skipSpaces() {
+		c := p.peek()
+		start := p.pos
+		switch {
+		case c == '[':
+			if expectName {
+				return nil, p.errorf("expected name, found '['")
+			}
+			st, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			st.offset = start
+			steps = append(steps, st)
+		case c == '.':
+			if expectName {
+				return nil, p.errorf("unexpected '.'")
+			}
+			if strings.HasPrefix(p.src[p.pos:], "..") {
+				st, err := p.parseDescent()
+				if err != nil {
+					return nil, err
+				}
+				st.offset = start
+				steps = append(steps, st)
+				continue
+			}
+			p.pos++
+			expectName = true
+		case c == '|':
+			if expectName || (len(steps) == 0 && !leadingDot) {
+				return nil, p.errorf("expected expression before '|'")
+			}
+			p.pos++
+			p.skipSpaces()
+			start = p.pos
+			st, err := p.parseStage()
+			if err != nil {
+				return nil, err
+			}
+			st.offset = start
+			steps = append(steps, st)
+		case c == '*' && !p.continuesName(p.pos+1):
+			if len(steps) > 0 && !expectName {
+				return nil, p.errorf("expected '.' or '[', found '*'")
+			}
+			p.pos++
+			steps = append(steps, step{kind: wildcardStep, source: "*", offset: start})
+			expectName = false
+		case isNameChar(c) || c == '*':
+			if len(steps) > 0 && !expectName {
+				return nil, p.errorf("expected '.' or '[', found %q", c)
+			}
+			st, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			st.offset = start
+			// $root can only start an expression, $parent can only follow another $parent
+			if st.kind == rootStep && len(steps) > 0 {
+				p.pos = start
+				return nil, p.errorf("%s can only start an expression", rootScope)
+			}
+			if st.kind == parentStep && len(steps) > 0 && steps[len(steps)-1].kind != parentStep {
+				p.pos = start
+				return nil, p.errorf("%s can only start an expression", parentScope)
+			}
+			steps = append(steps, st)
+			expectName = false
+		default:
+			return nil, p.errorf("unexpected %q", c)
+		}
+	}
+	return steps, nil
+}
+
+// parseName parses a field selector or a function call. As keys are free text, a name can contain spaces and
+// asterisks, as in `first name` or `a*b`, as long as more of the name follows them
+func (p *parser) parseName() (step, error) {
+	start := p.pos
+	for !p.eof() && (isNameChar(p.peek()) || p.peek() == '*' || p.continuesName(p.pos)) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	if p.peek() != '(' {
+		switch name {
+		case rootScope:
+			return step{kind: rootStep, name: name, source: name}, nil
+		case parentScope:
+			return step{kind: parentStep, name: name, source: name}, nil
+		}
+		return step{kind: fieldStep, name: name, source: name}, nil
+	}
+	params, err := p.parseParams()
+	if err != nil {
+		return step{}, err
+	}
+	return step{kind: callStep, name: name, params: params, source: p.src[start:p.pos]}, nil
+}
+
+// parseDescent parses a recursive descent, that is a double dot followed by a name
+func (p *parser) parseDescent() (step, error) {
+	p.pos += 2
+	start := p.pos
+	if p.eof() || !isNameChar(p.peek()) {
+		return step{}, p.errorf("expected name after '..'")
+	}
+	st, err := p.parseName()
+	if err != nil {
+		return step{}, err
+	}
+	if st.kind != fieldStep {
+		p.pos = start
+		return step{}, p.errorf("expected name after '..'")
+	}
+	return step{kind: descentStep, name: st.name, source: ".." + st.name}, nil
+}
+
+// parseStage parses the function of a pipe stage. Parentheses are optional when the function takes no parameters
+func (p *parser) parseStage() (step, error) {
+	p.skipSpaces()
+	if p.eof() || !isNameChar(p.peek()) {
+		return step{}, p.errorf("expected function after '|'")
+	}
+	st, err := p.parseName()
+	if err != nil || st.kind == callStep {
+		st.pipe = true
+		return st, err
+	}
+	return step{kind: callStep, name: st.name, params: []param{}, pipe: true, source: st.source}, nil
+}
+
+// parseParams parses the comma separated parameters of a function call, including the parentheses.
+// Parameters can be quoted strings, numbers, booleans, null, or unquoted text. Unquoted text is taken verbatim, and
+// a comma can be part of it if escaped with a backslash
+func (p *parser) parseParams() ([]param, error) {
+	open := p.pos
+	p.pos++
+	params := make([]param, 0)
+	for {
+		p.skipSpaces()
+		if p.eof() {
+			p.pos = open
+			return nil, p.errorf("unterminated function call")
+		}
+		if p.peek() == ')' && len(params) == 0 {
+			p.pos++
+			return params, nil
+		}
+		var prm param
+		var err error
+		if c := p.peek(); c == '"' || c == '\'' {
+			prm, err = p.parseQuoted()
+		} else {
+			prm, err = p.parseUnquoted()
+		}
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, prm)
+		p.skipSpaces()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return params, nil
+		case 0:
+			p.pos = open
+			return nil, p.errorf("unterminated function call")
+		default:
+			return nil, p.errorf("expected ',' or ')', found %q", p.peek())
+		}
+	}
+}
+
+// parseQuoted parses a string in single or double quotes. Backslash escapes the quote, the backslash itself, and
+// introduces the \n, \r and \t control characters
+func (p *parser) parseQuoted() (param, error) {
+	start := p.pos
+	quote := p.next()
+	sb := strings.Builder{}
+	for {
+		if p.eof() {
+			p.pos = start
+			return param{}, p.errorf("unterminated string")
+		}
+		c := p.next()
+		switch {
+		case c == quote:
+			return param{value: sb.String(), text: sb.String()}, nil
+		case c == '\\' && !p.eof():
+			switch e := p.next(); e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+// parseUnquoted parses a parameter that is not quoted, up to the next comma or to the closing parenthesis. Numbers,
+// booleans and null are recognised as such, anything else is text
+func (p *parser) parseUnquoted() (param, error) {
+	start := p.pos
+	sb := strings.Builder{}
+	depth := 0
+	for !p.eof() {
+		c := p.peek()
+		if (c == ',' || c == ')') && depth == 0 {
+			break
+		}
+		p.pos++
+		switch c {
+		case '\\':
+			// escaped commas are part of the parameter, any other backslash is preserved
+			if p.peek() == ',' {
+				c = p.next()
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		sb.WriteByte(c)
+	}
+	text := strings.TrimSpace(sb.String())
+	prm := param{value: literalValue(text), text: text}
+	// text that looks like a path can be evaluated. Paths starting with a dollar sign have to be valid
+	if val, ok := prm.value.(string); ok && val != "" {
+		path, err := Compile(text)
+		offset := start + strings.Index(sb.String(), text)
+		if err != nil && strings.HasPrefix(text, "$") {
+			var syntaxErr *SyntaxError
+			if errors.As(err, &syntaxErr) {
+				p.pos = offset + syntaxErr.Column - 1
+				return param{}, p.errorf("%s", syntaxErr.Msg)
+			}
+			return param{}, err
+		}
+		if path != nil {
+			path.shift(offset)
+		}
+		prm.path = path
+	}
+	return prm, nil
+}
+
+// literalValue returns the typed value of an unquoted parameter
+func literalValue(text string) any {
+	switch text {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if !numberRegex.MatchString(text) {
+		return text
+	}
+	if i, err := strconv.Atoi(text); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f
+	}
+	return text
+}
+
+// parseIndex parses an array index, a range of items, a wildcard, a filter, a quoted key, or a path whose value is the
+// index or the key, in square brackets
+func (p *parser) parseIndex() (step, error) {
+	start := p.pos
+	p.pos++
+	p.skipSpaces()
+	if c := p.peek(); c == '"' || c == '\'' {
+		// a quoted key can contain any character, including dots and brackets
+		key, err := p.parseQuoted()
+		if err != nil {
+			return step{}, err
+		}
+		p.skipSpaces()
+		if p.peek() != ']' {
+			return step{}, p.errorf("expected ']'")
+		}
+		p.pos++
+		return step{kind: fieldStep, name: key.text, source: p.src[start:p.pos]}, nil
+	}
+	if p.peek() == '?' {
+		pr, err := p.parseFilter()
+		if err != nil {
+			return step{}, err
+		}
+		if p.peek() != ']' {
+			return step{}, p.errorf("expected ']'")
+		}
+		p.pos++
+		return step{kind: filterStep, filter: pr, source: p.src[start:p.pos]}, nil
+	}
+	if p.peek() == '*' {
+		p.pos++
+		p.skipSpaces()
+		if p.peek() != ']' {
+			return step{}, p.errorf("expected ']'")
+		}
+		p.pos++
+		return step{kind: wildcardStep, source: p.src[start:p.pos]}, nil
+	}
+	if c := p.peek(); c != '-' && c != ':' && (c < '0' || c > '9') {
+		// anything else is a path, whose value is the index or the key
+		offset := p.pos
+		text, err := p.scanNested(func(c byte) bool { return c == ']' })
+		if err != nil {
+			return step{}, err
+		}
+		if strings.TrimSpace(text) == "" {
+			p.pos = offset
+			return step{}, p.errorf("expected array index")
+		}
+		path, err := p.compileNested(text, offset)
+		if err != nil {
+			return step{}, err
+		}
+		if p.eof() {
+			return step{}, p.errorf("expected ']'")
+		}
+		p.pos++
+		return step{kind: keyStep, path: path, source: p.src[start:p.pos]}, nil
+	}
+	// a range has up to three numbers, separated by colons, and each of them can be omitted
+	numbers := make([]*int, 0, 3)
+	for {
+		number, err := p.parseInteger()
+		if err != nil {
+			return step{}, err
+		}
+		numbers = append(numbers, number)
+		p.skipSpaces()
+		if p.peek() != ':' || len(numbers) == 3 {
+			break
+		}
+		p.pos++
+		p.skipSpaces()
+	}
+	if len(numbers) == 1 && numbers[0] == nil {
+		return step{}, p.errorf("expected array index")
+	}
+	if p.peek() != ']' {
+		return step{}, p.errorf("expected ']'")
+	}
+	p.pos++
+	source := p.src[start:p.pos]
+	if len(numbers) == 1 {
+		return step{kind: indexStep, index: *numbers[0], source: source}, nil
+	}
+	bounds := sliceBounds{start: numbers[0], end: numbers[1]}
+	if len(numbers) == 3 {
+		bounds.step = numbers[2]
+	}
+	if bounds.step != nil && *bounds.step == 0 {
+		p.pos = start
+		return step{}, p.errorf("slice step cannot be zero")
+	}
+	return step{kind: sliceStep, bounds: bounds, source: source}, nil
+}
+
+// scanNested moves the position past an expression nested in another one, up to the first character for which stop
+// is true, excluding the characters within brackets, parentheses or quotes. It returns the text of the nested
+// expression
+func (p *parser) scanNested(stop func(c byte) bool) (string, error) {
+	start := p.pos
+	depth := 0
+	for !p.eof() {
+		c := p.peek()
+		if depth == 0 && stop(c) {
+			break
+		}
+		switch c {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case '"', '\'':
+			if _, err := p.parseQuoted(); err != nil {
+				return "", err
+			}
+			continue
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos], nil
+}
+
+// compileNested compiles an expression nested in another one, found at offset. Syntax errors are reported with their
+// position in the outer expression
+func (p *parser) compileNested(text string, offset int) (*Expression, error) {
+	path, err := Compile(text)
+	if err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			p.pos = offset + syntaxErr.Column - 1
+			return nil, p.errorf("%s", syntaxErr.Msg)
+		}
+		return nil, err
+	}
+	path.shift(offset)
+	return path, nil
+}
+
+// shift moves the offsets of the steps of a nested expression, found at offset in the enclosing one, so that they are
+// relative to the enclosing expression. The expressions nested in the steps are moved as well
+func (e *Expression) shift(offset int) {
+	for i := range e.steps {
+		st := &e.steps[i]
+		st.offset += offset
+		if st.path != nil {
+			st.path.shift(offset)
+		}
+		st.filter.shift(offset)
+		for _, prm := range st.params {
+			if prm.path != nil {
+				prm.path.shift(offset)
+			}
+		}
+	}
+}
+
+// parseInteger parses an optionally negative integer. If there's no integer at the current position, it returns nil
+func (p *parser) parseInteger() (*int, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	digits := p.pos
+	for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+		p.pos++
+	}
+	if digits == p.pos {
+		if digits > start {
+			return nil, p.errorf("expected digits after '-'")
+		}
+		return nil, nil
+	}
+	number, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid array index")
+	}
+	return &number, nil
+}
+
+// errorf builds a SyntaxError at the current position
+func (p *parser) errorf(format string, args ...any) error {
+	return &SyntaxError{Expr: p.src, Column: p.pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// eof returns true when the whole source has been consumed
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+// peek returns the current character without consuming it, or zero at the end of the source
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// next consumes and returns the current character
+func (p *parser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	return c
+}
+
+// skipSpaces moves the position past any whitespace
+func (p *parser) skipSpaces() {
+	for !p.eof() && isSpace(p.peek()) {
+		p.pos++
+	}
+}
+
+// continuesName tells whether the characters at pos are part of a name: spaces and asterisks followed by a name
+// character, as in `first name`
+func (p *parser) continuesName(pos int) bool {
+	for pos < len(p.src) && (isSpace(p.src[pos]) || p.src[pos] == '*') {
+		pos++
+	}
+	return pos < len(p.src) && isNameChar(p.src[pos])
+}
+
+// isNameChar returns true if the character can be part of a field or function name
+func isNameChar(c byte) bool {
+	switch c {
+	case '.', '[', ']', '(', ')', '|', '*':
+		return false
+	}
+	return !isSpace(c)
+}
+
+// isName returns true if s is a valid field or function name
+func isName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isSpace returns true if the character is whitespace. Multibyte characters are never whitespace, so they can be
+// part of names
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,277 @@
+package gowalker
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// predicate is the boolean expression of a filter, as in `[?age > 25]`, evaluated against each item of an array
+// op is the operator: a comparison, `&&`, `||`, `!`, or empty if the predicate is an operand
+// left and right are the operands of the operator. The negation only has the left operand
+// value is the literal value of an operand
+// path is the expression of an operand that is a path, relative to the item being filtered
+type predicate struct {
+	op    string
+	left  *predicate
+	right *predicate
+	value any
+	path  *Expression
+}
+
+// comparisons are the comparison operators, in the order they're matched
+var comparisons = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// parseFilter parses the predicate of a filter, starting with the question mark and up to the closing bracket,
+// excluded
+func (p *parser) parseFilter() (*predicate, error) {
+	p.pos++
+	pr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	return pr, nil
+}
+
+// parseOr parses predicates joined by `||`
+func (p *parser) parseOr() (*predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpaces(); strings.HasPrefix(p.src[p.pos:], "||"); p.skipSpaces() {
+		p.pos += 2
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicate{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses predicates joined by `&&`
+func (p *parser) parseAnd() (*predicate, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpaces(); strings.HasPrefix(p.src[p.pos:], "&&"); p.skipSpaces() {
+		p.pos += 2
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicate{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses a predicate, optionally negated with `!`
+func (p *parser) parseNot() (*predicate, error) {
+	p.skipSpaces()
+	if p.peek() == '!' && !strings.HasPrefix(p.src[p.pos:], "!=") {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &predicate{op: "!", left: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses an operand, optionally compared with another one
+func (p *parser) parseComparison() (*predicate, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	for _, op := range comparisons {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return &predicate{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+// parseOperand parses a predicate in parentheses, a quoted string, a number, a boolean, null, or a path. A path ends
+// at the first whitespace or operator that is not within brackets, parentheses or quotes
+func (p *parser) parseOperand() (*predicate, error) {
+	p.skipSpaces()
+	switch c := p.peek(); {
+	case c == '(':
+		open := p.pos
+		p.pos++
+		pr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.peek() != ')' {
+			p.pos = open
+			return nil, p.errorf("unbalanced parenthesis")
+		}
+		p.pos++
+		return pr, nil
+	case c == '"' || c == '\'':
+		prm, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &predicate{value: prm.value}, nil
+	}
+	start := p.pos
+	text, err := p.scanNested(func(c byte) bool {
+		return isSpace(c) || strings.IndexByte("=!<>&|)]", c) >= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, p.errorf("expected operand")
+	}
+	if value := literalValue(text); value != text {
+		return &predicate{value: value}, nil
+	}
+	path, err := p.compileNested(text, start)
+	if err != nil {
+		return nil, err
+	}
+	return &predicate{path: path}, nil
+}
+
+// shift moves the offsets of the paths of the predicate. See Expression.shift
+func (pr *predicate) shift(offset int) {
+	if pr == nil {
+		return
+	}
+	if pr.path != nil {
+		pr.path.shift(offset)
+	}
+	pr.left.shift(offset)
+	pr.right.shift(offset)
+}
+
+// filter returns the items of data for which the predicate is true. The second return value is false if data is not
+// an array
+func filter(ctx context.Context, pr *predicate, data any, scope any, functions *Functions) ([]any, bool, error) {
+	t := reflect.ValueOf(data)
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return nil, false, nil
+	}
+	res := make([]any, 0)
+	for i := 0; i < t.Len(); i++ {
+		item := t.Index(i).Interface()
+		val, err := pr.eval(ctx, item, scope, functions)
+		if err != nil {
+			return nil, true, err
+		}
+		if isTruthy(val) {
+			res = append(res, item)
+		}
+	}
+	return res, true, nil
+}
+
+// eval evaluates the predicate against an item. Operands evaluate to their value, operators to a bool
+func (pr *predicate) eval(ctx context.Context, item any, scope any, functions *Functions) (any, error) {
+	switch pr.op {
+	case "":
+		if pr.path == nil {
+			return pr.value, nil
+		}
+		return walkImpl(withoutOutput(ctx), pr.path.steps, item, scope, functions)
+	case "!":
+		val, err := pr.left.eval(ctx, item, scope, functions)
+		return !isTruthy(val), err
+	}
+	left, err := pr.left.eval(ctx, item, scope, functions)
+	if err != nil {
+		return nil, err
+	}
+	// logical operators short-circuit
+	switch pr.op {
+	case "&&":
+		if !isTruthy(left) {
+			return false, nil
+		}
+	case "||":
+		if isTruthy(left) {
+			return true, nil
+		}
+	}
+	right, err := pr.right.eval(ctx, item, scope, functions)
+	if err != nil {
+		return nil, err
+	}
+	switch pr.op {
+	case "&&", "||":
+		return isTruthy(right), nil
+	case "==":
+		return equals(left, right), nil
+	case "!=":
+		return !equals(left, right), nil
+	}
+	cmp, ok := compare(left, right)
+	if !ok {
+		// values that cannot be ordered do not satisfy any ordering
+		return false, nil
+	}
+	switch pr.op {
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	default:
+		return cmp <= 0, nil
+	}
+}
+
+// equals tells whether a and b are equal. Numbers and strings are equal if they have the same value, regardless of
+// their type
+func equals(a any, b any) bool {
+	a, b = dereference(a), dereference(b)
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	if x, ok := toString(a); ok {
+		y, ok := toString(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare returns -1, 0 or 1 if a is less than, equal to, or greater than b. Only numbers and strings can be
+// compared, and the second return value is false for anything else
+func compare(a any, b any) (int, bool) {
+	a, b = dereference(a), dereference(b)
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok := toString(a)
+	y, ok2 := toString(b)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
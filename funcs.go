@@ -3,12 +3,21 @@ package gowalker
 import (
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io"
 	"reflect"
 	"strings"
 )
 
-type mapOfFunctions map[string]func(ctx context.Context, scope any, params ...string) (any, error)
+// function is the internal representation of a function. Only one of plain and typed is set
+// plain receives its parameters as strings
+// typed receives its parameters as typed values, with paths resolved
+type function struct {
+	plain func(ctx context.Context, scope any, params ...string) (any, error)
+	typed func(ctx context.Context, scope any, params ...any) (any, error)
+}
+
+type mapOfFunctions map[string]function
 
 // Functions is a map of actual Golang functions the expression can call.
 // When invoked, a function receives a variadic argument in which the first position is always the current selected
@@ -16,14 +25,20 @@ type mapOfFunctions map[string]func(ctx context.Context, scope any, params ...st
 // Functions will return a value and an error.
 // mapOfFunctions is the actual map of key=function
 // functionScope is an extra scope a function can access if part of Functions
+// jsonTags is true if struct fields can be selected by their json tag name
+// methods is true if the exported methods of the data can be invoked as functions
+// subTemplates holds the sub-templates registered by RenderAll, parsed once, by name
 type Functions struct {
 	mapOfFunctions
 	functionScope map[string]any
+	jsonTags      bool
+	methods       bool
+	subTemplates  map[string]*Template
 }
 
 // NewFunctions is the constructor of Functions and adds some very basic implementations
 func NewFunctions() *Functions {
-	fx := Functions{mapOfFunctions{}, map[string]any{}}
+	fx := Functions{mapOfFunctions{}, map[string]any{}, true, false, map[string]*Template{}}
 	fx.Add("size", fx.size)
 	fx.Add("split", fx.split)
 	fx.Add("collect", fx.collect)
@@ -36,9 +51,34 @@ func NewFunctions() *Functions {
 	return &fx
 }
 
-// Add adds a function ot the Functions' data structure
-func (f *Functions) Add(key string, function func(ctx context.Context, data any, params ...string) (any, error)) *Functions {
-	f.mapOfFunctions[key] = function
+// Add adds a function ot the Functions' data structure. The function receives its parameters as strings
+func (f *Functions) Add(key string, fn func(ctx context.Context, data any, params ...string) (any, error)) *Functions {
+	f.mapOfFunctions[key] = function{plain: fn}
+	return f
+}
+
+// AddTyped adds a function that receives its parameters as typed values to the Functions' data structure.
+// Quoted text is passed as a string, numbers as int or float64, booleans as bool, and null as nil. Unquoted
+// parameters are paths, and they're passed as the value they resolve to
+func (f *Functions) AddTyped(key string,
+	fn func(ctx context.Context, data any, params ...any) (any, error)) *Functions {
+	f.mapOfFunctions[key] = function{typed: fn}
+	return f
+}
+
+// JSONTags sets whether struct fields can be selected by the name in their json tag, as in `first_name`, so that the
+// same expressions work against structs and decoded JSON. Fields tagged `-` are skipped when iterating structs.
+// Go field names can always be used. It's enabled by default
+func (f *Functions) JSONTags(enabled bool) *Functions {
+	f.jsonTags = enabled
+	return f
+}
+
+// Methods sets whether the exported methods of the data can be invoked as functions, as in `user.FullName()`, when
+// no function with that name has been added. It's disabled by default, as a template could invoke any method of the
+// data it's rendered against, so only enable it for trusted templates
+func (f *Functions) Methods(enabled bool) *Functions {
+	f.methods = enabled
 	return f
 }
 
@@ -47,29 +87,35 @@ func (f *Functions) Add(key string, function func(ctx context.Context, data any,
 func (f *Functions) size(_ context.Context, scope any, _ ...string) (any, error) {
 	// if scope is nil, then we return an error
 	if scope == nil {
-		return nil, errors.New("nil reference to size function")
+		return nil, fmt.Errorf("%w: size of nil", ErrUnsupportedType)
+	}
+	if w, ok := scope.(Walkable); ok {
+		return w.Len(), nil
 	}
 	val := reflect.ValueOf(scope)
-	kind := reflect.TypeOf(scope).Kind()
+	kind := val.Kind()
 	switch kind {
-	// maps, slices and strings, all support Len
-	case reflect.Map, reflect.Slice, reflect.String:
+	// maps, slices, arrays and strings, all support Len
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
 		return val.Len(), nil
 	default:
 		// in any other cases, we return an error
-		return nil, errors.New("size not supported for: " + kind.String())
+		return nil, fmt.Errorf("%w: size of %s", ErrUnsupportedType, kind)
 	}
 }
 
 // split is one of the base functions for the user to invoke.
 // It splits a string into an array, given a separator
 func (f *Functions) split(_ context.Context, scope any, params ...string) (any, error) {
+	if len(params) < 1 {
+		return nil, fmt.Errorf("%w: separator", ErrMissingParameter)
+	}
 	// if the scope is a string, then we can proceed with the split
 	if val, ok := scope.(string); ok {
 		return strings.Split(val, params[0]), nil
 	} else {
 		// returning an error if attempting a split on a data type that is not a string
-		return nil, errors.New("split only supported for strings")
+		return nil, fmt.Errorf("%w: split of %T", ErrUnsupportedType, scope)
 	}
 }
 
@@ -78,24 +124,53 @@ func (f *Functions) split(_ context.Context, scope any, params ...string) (any,
 func (f *Functions) render(ctx context.Context, scope any, params ...string) (any, error) {
 	// returning an error if the sub-template name was not provided
 	if len(params) < 1 || len(params[0]) == 0 {
-		return nil, errors.New("template not provided")
+		return nil, fmt.Errorf("%w: template name", ErrMissingParameter)
 	}
 	// if the sub-template name is found, we can run Render against it
-	if templ, ok := f.functionScope["_"+params[0]]; ok {
-		return Render(ctx, templ.(string), scope, f)
-	} else {
+	templ, err := f.subTemplate(params[0])
+	if err != nil {
+		return nil, err
+	}
+	// if we're rendering to an output, the sub-template is written there directly
+	if w := outputWriter(ctx); w != nil {
+		return streamed{}, templ.ExecuteTo(ctx, w, scope, f)
+	}
+	return templ.Execute(ctx, scope, f)
+}
+
+// subTemplate returns the parsed sub-template with the given name. Sub-templates registered by RenderAll are parsed
+// once, while the others are parsed at every call
+func (f *Functions) subTemplate(name string) (*Template, error) {
+	templ, ok := f.functionScope["_"+name]
+	if !ok {
 		// returning an error if the template was not found
-		return nil, errors.New("template not found")
+		return nil, ErrTemplateNotFound
+	}
+	src, _ := templ.(string)
+	if parsed, ok := f.subTemplates[name]; ok && parsed.source == src {
+		return parsed, nil
+	}
+	return parseTemplate(src, true)
+}
+
+// addSubTemplates makes the sub-templates available to `render` and `renderEach`, and parses them once. A
+// sub-template that cannot be parsed is only reported when rendered
+func (f *Functions) addSubTemplates(subTemplates SubTemplates) {
+	for k, v := range subTemplates {
+		f.functionScope["_"+k] = v
+		if parsed, err := parseTemplate(v, true); err == nil {
+			f.subTemplates[k] = parsed
+		}
 	}
 }
 
 func (f *Functions) jsonEscape(_ context.Context, scope any, _ ...string) (any, error) {
-	if reflect.TypeOf(scope).Kind() == reflect.String {
+	if reflect.ValueOf(scope).Kind() == reflect.String {
 		data, err := json.Marshal(scope)
 		dataString := string(data)
 		return dataString[1 : len(dataString)-1], err
 	}
-	return scope, errors.New("cannot JSON-escape a data type that is not a string")
+	return scope, fmt.Errorf("%w: jsonEscape of %T", ErrUnsupportedType, scope)
 
 }
 
@@ -104,13 +179,14 @@ func (f *Functions) toVar(ctx context.Context, _ any, params ...string) (any, er
 	return Walk(ctx, params[0], f.functionScope, f)
 }
 
-// renderEach will render a sub-template against each element in the provided scope, assuming it's an array.
-// It requires one param that is the name of the sub-template. Additionally, it accepts a second param that is a
-// separator string to append at each iteration.
+// renderEach will render a sub-template against each element in the provided scope, assuming it's an array. Maps and
+// structs are iterated as key/value pairs. The metadata of each iteration are available to the sub-template as
+// `$loop`. It requires one param that is the name of the sub-template. Additionally, it accepts a second param that
+// is a separator string to append at each iteration.
 func (f *Functions) renderEach(ctx context.Context, scope any, params ...string) (any, error) {
 	// if there are no params, it's an error
 	if len(params) < 1 || len(params[0]) == 0 {
-		return nil, errors.New("template not provided")
+		return nil, fmt.Errorf("%w: template name", ErrMissingParameter)
 	}
 	// if it has two params, we have a separator string
 	sep := ""
@@ -118,116 +194,117 @@ func (f *Functions) renderEach(ctx context.Context, scope any, params ...string)
 		sep = params[1]
 	}
 	// if the sub-template exists
-	if templ, ok := f.functionScope["_"+params[0]]; ok {
-		if scope == nil {
-			return nil, errors.New("cannot renderEach against nil")
+	templ, err := f.subTemplate(params[0])
+	if err != nil {
+		return nil, err
+	}
+	if scope == nil {
+		return nil, ErrNotIterable
+	}
+	// if we're rendering to an output, each iteration is written there directly, otherwise we collect the strings
+	out := outputWriter(ctx)
+	res := strings.Builder{}
+	w := out
+	if w == nil {
+		w = &res
+	}
+	// against each item we render the sub-template, and print the separator if it's not the last item
+	err = forEach(ctx, scope, f.jsonTags, func(i int, size int, key any, item any) error {
+		if err := templ.ExecuteTo(ctx, w, withLoop(item, i, size, key), f); err != nil {
+			return err
 		}
-		// and the scope is a slice
-		switch reflect.TypeOf(scope).Kind() {
-		case reflect.Slice:
-			sliceVal := reflect.ValueOf(scope)
-			res := ""
-			// against each item in the slice
-			for i := 0; i < sliceVal.Len(); i++ {
-				// we render the sub-template
-				if tmp, err := Render(ctx, templ.(string), sliceVal.Index(i).Interface(), f); err == nil {
-					res = res + tmp
-					// if this is not the last item in the list, we print the separator character
-					if i < sliceVal.Len()-1 {
-						res += sep
-					}
-				} else {
-					// returning an error if Render failed
-					return nil, err
-				}
-			}
-			// returning the collected strings
-			return res, nil
-		case reflect.Map:
-			mapVal := reflect.ValueOf(scope)
-			res := ""
-			keysVal := mapVal.MapKeys()
-			for i := 0; i < len(keysVal); i++ {
-				key := keysVal[i].Interface()
-				val := mapVal.MapIndex(keysVal[i]).Interface()
-				scope := map[any]any{"key": key, "value": val}
-				if tmp, err := Render(ctx, templ.(string), scope, f); err == nil {
-					res = res + tmp
-					// if this is not the last item in the list, we print the separator character
-					if i < len(keysVal)-1 {
-						res += sep
-					}
-				} else {
-					// returning an error if Render failed
-					return nil, err
-				}
-			}
-			// returning the collected strings
-			return res, nil
-		default:
-			// returning an error if the data type of the scope was not a slice
-			return nil, errors.New("cannot iterate on a data type that is not an array")
+		if i < size-1 {
+			_, err := io.WriteString(w, sep)
+			return err
 		}
-	} else {
-		// returning an error if the template was not found
-		return nil, errors.New("template not found")
+		return nil
+	})
+	if err != nil {
+		// returning an error if Render failed
+		return nil, err
+	}
+	if out != nil {
+		return streamed{}, nil
 	}
+	// returning the collected strings
+	return res.String(), nil
 }
 
 // collect expects an array of objects to be the scope. Params is a list of fields we're interested in.
 // The function will produce a derivative array of objects containing only the fields expressed in params
-func (f *Functions) collect(_ context.Context, scope any, params ...string) (any, error) {
+func (f *Functions) collect(ctx context.Context, scope any, params ...string) (any, error) {
 	// if no params are passed, then we return an error
 	if len(params) < 1 || len(params[0]) == 0 {
-		return nil, errors.New("list of fields not provided")
-	}
-	kind := reflect.TypeOf(scope).Kind()
-	// if it's a slice, then the scope probably is the correct data type
-	if kind == reflect.Slice {
-		data := reflect.ValueOf(scope)
-		size := data.Len()
-		// let's create an array of maps that's going to hold the results
-		res := make([]map[string]any, size)
-		// iterating the original array
-		for i := 0; i < size; i++ {
-			item := data.Index(i).Interface()
-			// all elements have to be maps
-			if reflect.TypeOf(item).Kind() == reflect.Map {
-				// creating derivative element
-				block := map[string]any{}
-				// for each parameter expressed in the arguments
-				for _, p := range params {
-					// if we find an attribute with that name
-					found := reflect.ValueOf(item).MapIndex(reflect.ValueOf(p))
-					if found.IsValid() && !found.IsZero() && found.CanInterface() {
-						// we copy the value over
-						block[p] = found.Interface()
-					}
-				}
-				// assigning the newly created map to the new array
-				res[i] = block
-			} else {
-				// if the `kind` of a child object is not a map, then it's an error
-				return nil, errors.New("at least one item in the array is not a map")
-			}
+		return nil, fmt.Errorf("%w: list of fields", ErrMissingParameter)
+	}
+	var items []any
+	if w, ok := scope.(Walkable); ok {
+		// custom containers provide their own items
+		var err error
+		if items, err = walkableItems(ctx, w); err != nil {
+			return nil, err
 		}
-		// returning the derivative array
-		return res, nil
+	} else if kind := reflect.ValueOf(scope).Kind(); kind == reflect.Slice || kind == reflect.Array {
+		// if it's a slice, then the scope probably is the correct data type
+		items, _ = values(scope, f.jsonTags)
 	} else {
 		// if the given scope is not even an array, then we return an error
-		return nil, errors.New("operation can only be applied to arrays of maps")
+		return nil, ErrNotIterable
+	}
+	// let's create an array of maps that's going to hold the results
+	res := make([]map[string]any, len(items))
+	// iterating the original array
+	for i, it := range items {
+		// creating derivative element
+		block := map[string]any{}
+		if w, ok := dereference(it).(Walkable); ok {
+			// custom containers are asked for each parameter
+			for _, p := range params {
+				if val, found, err := w.WalkField(ctx, p); err != nil {
+					return nil, err
+				} else if found {
+					block[p] = val
+				}
+			}
+			res[i] = block
+			continue
+		}
+		item := reflect.ValueOf(it)
+		// all other elements have to be maps
+		if item.Kind() != reflect.Map {
+			// if the `kind` of a child object is not a map, then it's an error
+			return nil, fmt.Errorf("%w: collect of %T items", ErrUnsupportedType, it)
+		}
+		// for each parameter expressed in the arguments
+		for _, p := range params {
+			// the parameter has to be converted to the key type of the map
+			key, ok := convertKey(p, item.Type().Key())
+			if !ok {
+				continue
+			}
+			// if we find an attribute with that name
+			found := item.MapIndex(key)
+			if found.IsValid() && found.CanInterface() {
+				// we copy the value over
+				block[p] = found.Interface()
+			}
+		}
+		// assigning the newly created map to the new array
+		res[i] = block
 	}
+	// returning the derivative array
+	return res, nil
 }
 
 // toString will convert the provided scope to a string
-func (f *Functions) toString(_ context.Context, scope any, _ ...string) (any, error) {
-	return convertDataToString(scope), nil
+func (f *Functions) toString(ctx context.Context, scope any, _ ...string) (any, error) {
+	return convertDataToString(ctx, scope)
 }
 
 // eq will compare the provided scope with the provided param
 func (f *Functions) eq(_ context.Context, scope any, params ...string) (any, error) {
 	if len(params) < 1 {
-		return nil, errors.New("equality parameter not provided")
+		return nil, fmt.Errorf("%w: value to compare", ErrMissingParameter)
 	}
 	if comp, err := convertStringToSameType(scope, params[0]); err == nil {
 		return scope == comp, nil
@@ -242,58 +319,111 @@ func (f *Functions) GetScope() map[string]any {
 	return f.functionScope
 }
 
-// extractFunctionName will extract the function name from an expression. If the expression doesn't look like a function
-// call, then it returns an empty string
-func extractFunctionName(expr string) string {
-	names := functionExtractorRegex.FindStringSubmatch(expr)
-	if names != nil && len(names) > 1 {
-		return names[1]
-	}
-	return ""
-}
-
-// extractParameterString will extract the portion of the parameters from an expression that looks like a function call
-func extractParameterString(expr string) string {
-	names := functionExtractorRegex.FindStringSubmatch(expr)
-	if names != nil && len(names) > 2 {
-		return names[3]
+// runFunction will run the function expressed by the call step, against data, with the provided functions. The
+// parameters of the function are resolved against scope. Registered functions receive data dereferenced, while methods
+// are invoked on data as it is, so that methods with a pointer receiver modify the original value.
+// If the function ran, the first return value will be the result of the function execution, while the second is an
+// error, in case the function failed
+func runFunction(ctx context.Context, call step, data any, scope any, functions *Functions) (any, error) {
+	// If the provided functions do contain the one being invoked...
+	fn, ok := functions.mapOfFunctions[call.name]
+	if !ok {
+		// if methods are enabled, the data may have a method with that name
+		if functions.methods {
+			if method, found := findMethod(data, call.name); found {
+				return callMethod(ctx, method, call, scope, functions)
+			}
+		}
+		// otherwise, as the function was not found, the function call is returned as value, so it can be printed.
+		return call.source, ErrFunctionNotFound
+	}
+	data = dereference(data)
+	// ... we resolve the parameters, run it and return the result
+	if fn.typed != nil {
+		values := make([]any, len(call.params))
+		for i, p := range call.params {
+			val, err := p.resolve(ctx, scope, functions, true)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = val
+		}
+		return fn.typed(ctx, data, values...)
+	}
+	texts := make([]string, len(call.params))
+	for i, p := range call.params {
+		text, err := p.resolveText(ctx, scope, functions)
+		if err != nil {
+			return nil, err
+		}
+		texts[i] = text
 	}
-	return ""
+	return fn.plain(ctx, data, texts...)
 }
 
-// extractParameters will try to extract the parameters from a function call string
-func extractParameters(signature string) []string {
-	paramString := extractParameterString(signature)
-	params := paramExtractRegex.FindAllString(paramString, -1)
-	for i, p := range params {
-		params[i] = strings.ReplaceAll(p, "\\,", ",")
+// findMethod returns the exported method of data with the given name. If data is a pointer, its methods are invoked
+// on the value it points to. Otherwise, methods with a pointer receiver are found as well, and they're invoked
+// against a copy of data
+func findMethod(data any, name string) (reflect.Value, bool) {
+	val := reflect.ValueOf(data)
+	// pointers to pointers are followed down to the pointer to the value
+	for val.Kind() == reflect.Pointer && !val.IsNil() && val.Elem().Kind() == reflect.Pointer {
+		val = val.Elem()
+	}
+	if !val.IsValid() || (val.Kind() == reflect.Pointer && val.IsNil()) {
+		return reflect.Value{}, false
 	}
-	if len(params) == 1 && params[0] == "" {
-		return make([]string, 0)
+	if method := val.MethodByName(name); method.IsValid() {
+		return method, true
 	}
-	return params
+	if val.Kind() == reflect.Pointer {
+		return reflect.Value{}, false
+	}
+	ptr := reflect.New(val.Type())
+	ptr.Elem().Set(val)
+	method := ptr.MethodByName(name)
+	return method, method.IsValid()
 }
 
-// runFunction will try to run the function expressed by expr, against data, with the provided functions.
-// The first return value will be `true` if a function was indeed found in expr and the execution of the function
-// was attempted. If the functions ran, the second return value will be the result of the function execution.
-// The third parameter is an error, in case the function failed
-func runFunction(ctx context.Context, expr string, data any, functions *Functions) (bool, any, error) {
-	// Extracting the function name. If empty, then this is not a function call
-	if fx := extractFunctionName(expr); fx != "" {
-		// If it's a function call, though, we extract the parameters
-		params := extractParameters(expr)
-		// If the provided functions do contain the one being invoked...
-		if function, ok := functions.mapOfFunctions[fx]; ok {
-			// ... we can run it and return the result
-			res, err := function(ctx, data, params...)
-			return true, res, err
+// callMethod invokes a method with the parameters of the call step. Parameters are passed as functions added with
+// Add receive them, so only the ones starting with a dollar sign are evaluated, and they're converted to the types
+// the method expects. Methods can return nothing, a value, an error, or a value and an error
+func callMethod(ctx context.Context, method reflect.Value, call step, scope any, functions *Functions) (any, error) {
+	t := method.Type()
+	if len(call.params) != t.NumIn() && !(t.IsVariadic() && len(call.params) >= t.NumIn()-1) {
+		return nil, fmt.Errorf("method %s expects %d parameters, %d provided", call.name, t.NumIn(), len(call.params))
+	}
+	args := make([]reflect.Value, len(call.params))
+	for i, p := range call.params {
+		val, err := p.resolve(ctx, scope, functions, false)
+		if err != nil {
+			return nil, err
+		}
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= t.NumIn()-1 {
+			paramType = t.In(t.NumIn() - 1).Elem()
 		} else {
-			// otherwise, we still report that the function was detected, but as it was not found, the function call
-			// is returned as value, so it can be printed.
-			return true, expr, errors.New("function not found")
+			paramType = t.In(i)
+		}
+		if args[i], err = assignable(val, paramType); err != nil {
+			return nil, fmt.Errorf("method %s: parameter %d: %s", call.name, i+1, err)
+		}
+	}
+	out := method.Call(args)
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	switch {
+	case len(out) == 0:
+		return nil, nil
+	case len(out) == 1 && t.Out(0) == errorType:
+		err, _ := out[0].Interface().(error)
+		return nil, err
+	case len(out) == 1:
+		return out[0].Interface(), nil
+	case len(out) == 2 && t.Out(1) == errorType:
+		if err, _ := out[1].Interface().(error); err != nil {
+			return nil, err
 		}
+		return out[0].Interface(), nil
 	}
-	// If no functions were found, we report back
-	return false, "", nil
+	return nil, fmt.Errorf("method %s returns values that are not supported", call.name)
 }
@@ -1,5 +1,3 @@
 module github.com/theirish81/gowalker
 
 go 1.18
-
-require github.com/dlclark/regexp2 v1.7.0
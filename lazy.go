@@ -0,0 +1,194 @@
+package gowalker
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// Resolver is implemented by values that are expensive to compute, and should only be computed if an expression
+// reaches them. Values of type `func(context.Context) (any, error)` are resolved the same way. Within a render, or an
+// evaluation, each value is resolved once and the result is reused
+type Resolver interface {
+	Resolve(ctx context.Context) (any, error)
+}
+
+// lazyCacheKey is the context key holding the results of the lazy values resolved so far
+type lazyCacheKey struct{}
+
+// lazyCache holds the results of the lazy values resolved within a render. Resolvers are cached by their identity,
+// and functions, which cannot be compared, by their location
+type lazyCache struct {
+	mu      sync.Mutex
+	results map[any]any
+}
+
+// lazyContext is a context holding a cache for lazy values. The cache is part of the context, so that evaluating an
+// expression against data without lazy values only allocates the context
+type lazyContext struct {
+	context.Context
+	cache lazyCache
+}
+
+// Value returns the cache for lazy values, or the value ctx holds for key
+func (c *lazyContext) Value(key any) any {
+	if key == (lazyCacheKey{}) {
+		return &c.cache
+	}
+	return c.Context.Value(key)
+}
+
+// withLazyCache returns a context holding a cache for lazy values, unless ctx holds one already, as when a
+// sub-template is rendered within a template
+func withLazyCache(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(lazyCacheKey{}).(*lazyCache); ok {
+		return ctx
+	}
+	return &lazyContext{Context: ctx}
+}
+
+// location is where a value was found, such as a map key or an array index. container is the address of the map,
+// the array or the struct holding the value
+type location struct {
+	container uintptr
+	key       any
+}
+
+// locatedFunc is a lazy function bound to its location, which identifies it in the cache
+type locatedFunc struct {
+	fn func(context.Context) (any, error)
+	at location
+}
+
+// locate binds item to its location, if it's a lazy function. container is the map, the array or the pointer to the
+// struct holding item, and key is the key, index or field name of item. Items held by containers that do not have an
+// address, as structs that are not behind a pointer, cannot be located
+func locate(item any, container reflect.Value, key any) any {
+	fn, ok := item.(func(context.Context) (any, error))
+	if !ok {
+		return item
+	}
+	switch container.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Pointer:
+		if !container.IsNil() {
+			return locatedFunc{fn: fn, at: location{container: container.Pointer(), key: key}}
+		}
+	}
+	return item
+}
+
+// resolveLazy resolves data, if it's a Resolver or a function returning the actual value, until it's neither. The
+// results are cached in ctx, if it holds a cache. Resolvers that cannot be used as map keys, and functions whose
+// location is unknown, are not cached
+func resolveLazy(ctx context.Context, data any) (any, error) {
+	for {
+		var key any
+		var fn func(context.Context) (any, error)
+		switch lazy := data.(type) {
+		case Resolver:
+			fn = lazy.Resolve
+			if reflect.TypeOf(lazy).Comparable() {
+				key = lazy
+			}
+		case locatedFunc:
+			fn = lazy.fn
+			key = lazy.at
+		case func(context.Context) (any, error):
+			fn = lazy
+		default:
+			return data, nil
+		}
+		cache, _ := ctx.Value(lazyCacheKey{}).(*lazyCache)
+		res, ok := cache.get(key)
+		if !ok {
+			var err error
+			if res, err = fn(ctx); err != nil {
+				return nil, err
+			}
+			cache.set(key, res)
+		}
+		data = res
+	}
+}
+
+// resolveNested resolves data, and the lazy values within its maps, slices, arrays and pointers, so that data can be
+// marshalled with the values they resolve to. Structs, and values implementing json.Marshaler, are returned as they
+// are. visited holds the containers being resolved, so that cyclic data structures do not loop forever
+func resolveNested(ctx context.Context, data any, visited map[uintptr]bool) (any, error) {
+	data, err := resolveLazy(ctx, data)
+	if err != nil || data == nil {
+		return data, err
+	}
+	if _, ok := data.(json.Marshaler); ok {
+		return data, nil
+	}
+	val := reflect.ValueOf(data)
+	switch val.Kind() {
+	case reflect.Array:
+		// arrays are values, so they cannot contain themselves
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if val.IsNil() || visited[val.Pointer()] {
+			return data, nil
+		}
+		visited[val.Pointer()] = true
+		defer delete(visited, val.Pointer())
+	default:
+		return data, nil
+	}
+	if val.Kind() == reflect.Pointer {
+		return resolveNested(ctx, val.Elem().Interface(), visited)
+	}
+	// only the containers of values that can be lazy are rebuilt, so that []byte is still marshalled as base64
+	switch val.Type().Elem().Kind() {
+	case reflect.Interface, reflect.Func, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Array:
+	default:
+		return data, nil
+	}
+	anyType := reflect.TypeOf((*any)(nil)).Elem()
+	if val.Kind() == reflect.Map {
+		res := reflect.MakeMapWithSize(reflect.MapOf(val.Type().Key(), anyType), val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			item, err := resolveNested(ctx, iter.Value().Interface(), visited)
+			if err != nil {
+				return nil, err
+			}
+			// the address of item is taken so that a nil item is set as a nil interface
+			res.SetMapIndex(iter.Key(), reflect.ValueOf(&item).Elem())
+		}
+		return res.Interface(), nil
+	}
+	res := make([]any, val.Len())
+	for i := range res {
+		if res[i], err = resolveNested(ctx, val.Index(i).Interface(), visited); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// get returns the cached result of the lazy value identified by key. A nil cache, or a nil key, never holds a result
+func (c *lazyCache) get(key any) (any, bool) {
+	if c == nil || key == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.results[key]
+	return res, ok
+}
+
+// set caches the result of the lazy value identified by key, if there's a cache and a key. The results are allocated
+// when the first lazy value is resolved
+func (c *lazyCache) set(key any, res any) {
+	if c == nil || key == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.results == nil {
+		c.results = map[any]any{}
+	}
+	c.results[key] = res
+}
@@ -0,0 +1,269 @@
+package gowalker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Set sets the value at the path described by expr in data. The expression can select map keys, array indexes and
+// exported struct fields. Maps and slices are modified in place, while structs, and slices that need to grow, have to
+// be passed as a pointer. Set returns an error if a container in the path is missing
+func Set(ctx context.Context, expr string, data any, value any) error {
+	expression, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+	return expression.Set(ctx, data, value)
+}
+
+// SetCreate is like Set, but it creates the maps and slices that are missing in the path. Missing containers become
+// maps, if selected by key, or slices, if selected by index, and slices grow to fit the index
+func SetCreate(ctx context.Context, expr string, data any, value any) error {
+	expression, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+	return expression.SetCreate(ctx, data, value)
+}
+
+// Set sets the value at the path described by the expression in data. See Set
+func (e *Expression) Set(ctx context.Context, data any, value any) error {
+	return e.set(ctx, data, value, false)
+}
+
+// SetCreate sets the value at the path described by the expression in data, creating the maps and slices that are
+// missing in the path. See SetCreate
+func (e *Expression) SetCreate(ctx context.Context, data any, value any) error {
+	return e.set(ctx, data, value, true)
+}
+
+// set sets the value at the path of the expression in data, optionally creating the missing containers
+func (e *Expression) set(ctx context.Context, data any, value any, create bool) error {
+	if len(e.steps) == 0 {
+		return errors.New("cannot set the whole data")
+	}
+	s := setter{ctx: withoutOutput(ctx), value: value, scope: data, create: create, functions: NewFunctions()}
+	err := modify(data, func(target reflect.Value) (reflect.Value, error) {
+		return s.set(e.steps, target)
+	})
+	return withExpr(err, e.source)
+}
+
+// modify replaces data with the result of fn. Pointers are replaced with the value they point to, while maps and
+// slices can only be modified in place, as the caller would not see a reallocated copy
+func modify(data any, fn func(target reflect.Value) (reflect.Value, error)) error {
+	target := reflect.ValueOf(data)
+	switch target.Kind() {
+	case reflect.Pointer:
+		if target.IsNil() {
+			return fmt.Errorf("%w: nil pointer", ErrNotAddressable)
+		}
+		res, err := fn(target.Elem())
+		if err != nil {
+			return err
+		}
+		target.Elem().Set(res)
+		return nil
+	case reflect.Map, reflect.Slice:
+		res, err := fn(target)
+		if err != nil {
+			return err
+		}
+		// maps and slices share their items with data, unless they've been reallocated
+		if res.Pointer() != target.Pointer() || (res.Kind() == reflect.Slice && res.Len() != target.Len()) {
+			return fmt.Errorf("%w: cannot reallocate %T, pass a pointer", ErrNotAddressable, data)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: cannot modify %T, pass a pointer", ErrNotAddressable, data)
+}
+
+// setter holds the state of a Set operation
+// value is the value to set
+// scope is the data Set was invoked with, against which paths in square brackets are evaluated
+// create is true if missing containers have to be created
+type setter struct {
+	ctx       context.Context
+	value     any
+	scope     any
+	create    bool
+	functions *Functions
+}
+
+// set returns current, with the value at steps replaced by the value to set. As current may not be addressable, the
+// caller has to store the result in place of current
+func (s setter) set(steps []step, current reflect.Value) (reflect.Value, error) {
+	if err := checkContext(s.ctx); err != nil {
+		return current, err
+	}
+	if len(steps) == 0 {
+		return assignable(s.value, current.Type())
+	}
+	st := steps[0]
+	key, err := stepKey(s.ctx, st, s.scope, s.functions)
+	if err != nil {
+		return current, stepError(st, err)
+	}
+	switch current.Kind() {
+	case reflect.Interface:
+		if current.IsNil() {
+			if !s.create {
+				return current, stepError(st, ErrPathNotFound)
+			}
+			// a missing container becomes a map, if selected by key, or a slice, if selected by index
+			if _, ok := key.(string); ok {
+				return s.set(steps, reflect.ValueOf(map[string]any{}))
+			}
+			return s.set(steps, reflect.ValueOf([]any{}))
+		}
+		return s.set(steps, copyValue(current.Elem()))
+	case reflect.Pointer:
+		if current.IsNil() {
+			if !s.create {
+				return current, stepError(st, ErrNilContainer)
+			}
+			current = reflect.New(current.Type().Elem())
+		}
+		res, err := s.set(steps, current.Elem())
+		if err != nil {
+			return current, err
+		}
+		current.Elem().Set(res)
+		return current, nil
+	case reflect.Map:
+		if current.IsNil() {
+			if !s.create {
+				return current, stepError(st, ErrNilContainer)
+			}
+			current = reflect.MakeMap(current.Type())
+		}
+		k, ok := convertKey(key, current.Type().Key())
+		if !ok {
+			return current, stepError(st, keyError(st, key, "cannot be converted to "+current.Type().Key().String()))
+		}
+		item := current.MapIndex(k)
+		if !item.IsValid() {
+			if len(steps) > 1 && !s.create {
+				return current, stepError(st, ErrPathNotFound)
+			}
+			item = reflect.Zero(current.Type().Elem())
+		}
+		res, err := s.set(steps[1:], copyValue(item))
+		if err != nil {
+			return current, stepError(st, err)
+		}
+		current.SetMapIndex(k, res)
+		return current, nil
+	case reflect.Slice, reflect.Array:
+		index, ok := key.(int)
+		if !ok {
+			return current, stepError(st, keyError(st, key, "array index is not an integer"))
+		}
+		if index < 0 {
+			index += current.Len()
+		}
+		if index >= current.Len() && s.create && current.Kind() == reflect.Slice {
+			// the slice grows to fit the index
+			grow := index - current.Len() + 1
+			current = reflect.AppendSlice(current, reflect.MakeSlice(current.Type(), grow, grow))
+		}
+		if index < 0 || index >= current.Len() {
+			return current, stepError(st, ErrIndexOutOfBounds)
+		}
+		current = copyValue(current)
+		res, err := s.set(steps[1:], copyValue(current.Index(index)))
+		if err != nil {
+			return current, stepError(st, err)
+		}
+		current.Index(index).Set(res)
+		return current, nil
+	case reflect.Struct:
+		name, ok := key.(string)
+		if !ok {
+			return current, stepError(st, keyError(st, key, "field name is not a string"))
+		}
+		field, found := findField(current.Type(), name, s.functions.jsonTags)
+		if !found {
+			return current, stepError(st, ErrPrivateField)
+		}
+		current = copyValue(current)
+		target, err := current.FieldByIndexErr(field.index)
+		if err != nil {
+			// the field is promoted from a nil embedded struct
+			return current, stepError(st, ErrNilContainer)
+		}
+		res, err := s.set(steps[1:], copyValue(target))
+		if err != nil {
+			return current, stepError(st, err)
+		}
+		target.Set(res)
+		return current, nil
+	}
+	return current, stepError(st, ErrPathNotFound)
+}
+
+// stepKey returns the key a step selects: a string for fields, and an int for indexes. Paths in square brackets are
+// evaluated against scope, and integral values become indexes. Steps that do not select a single key return an error
+func stepKey(ctx context.Context, st step, scope any, functions *Functions) (any, error) {
+	switch st.kind {
+	case fieldStep:
+		return st.name, nil
+	case indexStep:
+		return st.index, nil
+	case keyStep:
+		key, err := walkImpl(ctx, st.path.steps, scope, scope, functions)
+		if err != nil {
+			return nil, err
+		}
+		key = dereference(key)
+		if key == nil {
+			return nil, &KeyError{Path: st.path.source, Msg: "resolved to nil"}
+		}
+		if index, ok := toInt(key); ok {
+			return index, nil
+		}
+		return key, nil
+	}
+	return nil, ErrMultipleValues
+}
+
+// keyError returns a *KeyError for the key selected by st, that cannot be used as msg explains. The value of a path in
+// square brackets is reported along with the path
+func keyError(st step, key any, msg string) *KeyError {
+	if st.path == nil {
+		return &KeyError{Path: fmt.Sprint(key), Msg: msg}
+	}
+	return &KeyError{Path: st.path.source, Key: key, Msg: msg}
+}
+
+// copyValue returns an addressable copy of val, so that its fields and items can be set. Values that are already
+// addressable, and the items of slices, share their memory with the original
+func copyValue(val reflect.Value) reflect.Value {
+	if val.CanSet() || val.Kind() == reflect.Slice || val.Kind() == reflect.Map {
+		return val
+	}
+	res := reflect.New(val.Type()).Elem()
+	res.Set(val)
+	return res
+}
+
+// assignable returns value as a reflect.Value that can be assigned to a variable of type t. Numbers are converted to
+// other numeric types, as long as t can represent them, and nil becomes the zero value of t
+func assignable(value any, t reflect.Type) (reflect.Value, error) {
+	val := reflect.ValueOf(value)
+	if !val.IsValid() {
+		return reflect.Zero(t), nil
+	}
+	if val.Type().AssignableTo(t) {
+		return val, nil
+	}
+	if _, ok := toFloat(value); ok && val.Type().ConvertibleTo(t) && t.Kind() != reflect.String {
+		if res, ok := convertNumber(val, t); ok {
+			return res, nil
+		}
+		return val, fmt.Errorf("%v cannot be represented as %s", value, t)
+	}
+	return val, fmt.Errorf("cannot assign %T to %s", value, t)
+}
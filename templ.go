@@ -2,51 +2,226 @@ package gowalker
 
 import (
 	"context"
-	"errors"
+	"io"
 	"strings"
 )
 
-// Render renders a template, using the provided map as scope. Will return the rendered template or an error
-func Render(ctx context.Context, template string, data any, functions *Functions) (string, error) {
-	if deadlineMet(ctx) {
-		return "", errors.New("deadline exceeded")
-	}
-	if hasCancelled(ctx) {
-		return "", errors.New("cancelled")
-	}
-	// let's first find all the template markers
-	items := templateFinderRegex.FindAllStringSubmatch(template, -1)
-	// for each marker...
-	for _, item := range items {
-		// matcher is the exact expression, including dollar sign and brackets
-		matcher := item[0]
-		// expr is what's within the brackets
-		expr := item[1]
-		// let's walk the path for the expression against the provided data
-		if val, err := Walk(ctx, expr, data, functions); err == nil {
-			// if the value is not nil...
-			if val != nil {
-				// then we replace the matcher with what we've found.
-				// If the value is nil, this won't happen and the matcher will be left untouched
-				template = strings.Replace(template, matcher, convertDataToString(val), 1)
+// Template is a parsed template. Parsing a template once and executing it many times avoids scanning the template
+// for markers and compiling its expressions at every render. A Template is immutable, therefore it can be executed
+// concurrently
+type Template struct {
+	source string
+	nodes  []templateNode
+}
+
+// ParseTemplate parses a template into a Template that can be executed multiple times
+func ParseTemplate(src string) (*Template, error) {
+	return parseTemplate(src, false)
+}
+
+// parseTemplate parses a template. When lenient, markers that are not valid expressions, such as `${a + b}` in an
+// embedded script, are kept as literal text instead of failing
+func parseTemplate(src string, lenient bool) (*Template, error) {
+	p := templateParser{src: src, lenient: lenient}
+	nodes, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Template{source: src, nodes: nodes}, nil
+}
+
+// MustParseTemplate is like ParseTemplate but panics if the template cannot be parsed
+func MustParseTemplate(src string) *Template {
+	t, err := ParseTemplate(src)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the source of the template
+func (t *Template) String() string {
+	return t.source
+}
+
+// Execute renders the template, using the provided data as scope. Will return the rendered template or an error
+func (t *Template) Execute(ctx context.Context, data any, functions *Functions) (string, error) {
+	sb := strings.Builder{}
+	if err := t.ExecuteTo(ctx, &sb, data, functions); err != nil {
+		return "", err
+	}
+	// returning the results of our effort
+	return sb.String(), nil
+}
+
+// ExecuteTo renders the template, using the provided data as scope, and writes the result to w as it goes.
+// Will return an error if the rendering or writing to w failed
+func (t *Template) ExecuteTo(ctx context.Context, w io.Writer, data any, functions *Functions) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	if functions == nil {
+		functions = NewFunctions()
+	}
+	// functions that produce output, such as render and renderEach, can write directly to w
+	ctx = context.WithValue(withLazyCache(ctx), outputKey{}, w)
+	return executeNodes(withScope(ctx, data), w, t.nodes, data, functions)
+}
+
+// executeNodes renders a list of template nodes and writes the result to w
+func executeNodes(ctx context.Context, w io.Writer, nodes []templateNode, data any, functions *Functions) error {
+	for _, node := range nodes {
+		switch node.kind {
+		case textNode:
+			if _, err := io.WriteString(w, node.text); err != nil {
+				return err
+			}
+		case exprNode:
+			if err := executeExpression(ctx, w, node, data, functions); err != nil {
+				return err
+			}
+		case ifNode:
+			if err := executeIf(ctx, w, node, data, functions); err != nil {
+				return err
+			}
+		case eachNode:
+			if err := executeEach(ctx, w, node, data, functions); err != nil {
+				return err
 			}
+		}
+	}
+	return nil
+}
 
-		} else {
-			// if there was an error, we return it
-			return template, err
+// executeExpression evaluates a marker and writes its value to w
+func executeExpression(ctx context.Context, w io.Writer, node templateNode, data any, functions *Functions) error {
+	// let's walk the path for the expression against the provided data
+	val, err := walkImpl(ctx, node.expression.steps, data, data, functions)
+	if err != nil {
+		// if there was an error, we return it
+		return withExpr(err, node.expression.source)
+	}
+	text := node.text
+	if _, ok := val.(streamed); ok {
+		// the output has already been written
+		return nil
+	} else if val != nil {
+		if text, err = convertDataToString(ctx, val); err != nil {
+			return withExpr(err, node.expression.source)
 		}
 	}
-	// returning the results of our effort
-	return template, nil
+	// If the value is nil, the marker is left untouched
+	_, err = io.WriteString(w, text)
+	return err
+}
+
+// executeIf renders the first branch of a conditional block whose condition is truthy
+func executeIf(ctx context.Context, w io.Writer, node templateNode, data any, functions *Functions) error {
+	for _, b := range node.branches {
+		// the else branch has no condition and always matches
+		if b.condition != nil {
+			val, err := walkImpl(withoutOutput(ctx), b.condition.steps, data, data, functions)
+			if err != nil {
+				return withExpr(err, b.condition.source)
+			}
+			if !isTruthy(val) {
+				continue
+			}
+		}
+		return executeNodes(ctx, w, b.nodes, data, functions)
+	}
+	return nil
+}
+
+// executeEach renders the body of a loop block for each item in the collection. If the loop has a variable, the
+// item is available under its name, alongside the outer scope. Otherwise, the item is the scope of the body. The
+// metadata of each iteration are available as `$loop`
+func executeEach(ctx context.Context, w io.Writer, node templateNode, data any, functions *Functions) error {
+	collection, err := walkImpl(withoutOutput(ctx), node.expression.steps, data, data, functions)
+	if err != nil || collection == nil {
+		return withExpr(err, node.expression.source)
+	}
+	return forEach(ctx, collection, functions.jsonTags, func(i int, size int, key any, item any) error {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		var scope any = variableScope{name: node.variable, value: item, outer: data}
+		if node.variable == "" {
+			scope = item
+		}
+		scope = withLoop(scope, i, size, key)
+		// the scope enclosing the loop becomes the parent scope of the iteration
+		iterationCtx := withScope(ctx, scope)
+		if err := executeNodes(iterationCtx, w, node.branches[0].nodes, scope, functions); err != nil {
+			return err
+		}
+		// the separator is rendered between an iteration and the next
+		if len(node.branches) > 1 && i < size-1 {
+			return executeNodes(iterationCtx, w, node.branches[1].nodes, scope, functions)
+		}
+		return nil
+	})
+}
+
+// outputKey is the context key holding the writer a template is being rendered to
+type outputKey struct{}
+
+// streamed is returned by functions that wrote their result directly to the output writer
+type streamed struct{}
+
+// outputWriter returns the writer a function can write its result to, or nil if the function result is not going to
+// be written to an output as-is
+func outputWriter(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(outputKey{}).(io.Writer); ok {
+		return w
+	}
+	return nil
+}
+
+// withoutOutput returns a context from which functions cannot write to the output writer
+func withoutOutput(ctx context.Context) context.Context {
+	if outputWriter(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, outputKey{}, nil)
+}
+
+// Render renders a template, using the provided map as scope. Will return the rendered template or an error.
+// Markers that are not valid expressions are rendered as they are
+func Render(ctx context.Context, template string, data any, functions *Functions) (string, error) {
+	t, err := parseTemplate(template, true)
+	if err != nil {
+		return template, err
+	}
+	return t.Execute(ctx, data, functions)
+}
+
+// RenderTo renders a template, using the provided map as scope, and writes the result to w as it goes. Markers that
+// are not valid expressions are rendered as they are
+func RenderTo(ctx context.Context, w io.Writer, template string, data any, functions *Functions) error {
+	t, err := parseTemplate(template, true)
+	if err != nil {
+		return err
+	}
+	return t.ExecuteTo(ctx, w, data, functions)
 }
 
 // RenderAll will render the provided templates, making subTemplates available for complex rendering
 func RenderAll(ctx context.Context, template string, subTemplates SubTemplates, data any, functions *Functions) (string, error) {
-	if subTemplates == nil {
-		subTemplates = NewSubTemplates()
-	}
-	for k, v := range subTemplates {
-		functions.functionScope["_"+k] = v
+	if functions == nil {
+		functions = NewFunctions()
 	}
+	functions.addSubTemplates(subTemplates)
 	return Render(ctx, template, data, functions)
 }
+
+// RenderAllTo will render the provided templates, making subTemplates available for complex rendering, and writes
+// the result to w as it goes
+func RenderAllTo(ctx context.Context, w io.Writer, template string, subTemplates SubTemplates, data any,
+	functions *Functions) error {
+	if functions == nil {
+		functions = NewFunctions()
+	}
+	functions.addSubTemplates(subTemplates)
+	return RenderTo(ctx, w, template, data, functions)
+}
@@ -0,0 +1,304 @@
+package gowalker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// nodeKind is the type of a template node
+type nodeKind int
+
+const (
+	// textNode is a literal chunk of text
+	textNode nodeKind = iota
+	// exprNode is a marker to evaluate
+	exprNode
+	// ifNode is a conditional block
+	ifNode
+	// eachNode is a loop block
+	eachNode
+)
+
+// templateNode is one node of a parsed template.
+// kind is the type of node
+// text is the literal text, or the whole marker, including dollar sign and brackets, for expression nodes
+// expression is the compiled expression within the brackets, for expression nodes, or the collection to iterate, for
+// loop blocks
+// variable is the name of the loop variable, for loop blocks. If empty, each item becomes the scope of the iteration
+// branches are the alternatives of a conditional block, in order. For loop blocks, the first branch is the body and
+// the optional second branch is the separator
+type templateNode struct {
+	kind       nodeKind
+	text       string
+	expression *Expression
+	variable   string
+	branches   []branch
+}
+
+// branch is one of the alternatives of a conditional block, or a section of a loop block. The `else` branch has no
+// condition
+type branch struct {
+	condition *Expression
+	nodes     []templateNode
+}
+
+// block is a block being parsed, waiting for its closing marker
+// node is the block node
+// keyword is the directive that opened the block
+// offset is the position of the opening marker in the template
+// hasElse is true if the `else` branch, or the separator of a loop, has been found
+type block struct {
+	node    *templateNode
+	keyword string
+	offset  int
+	hasElse bool
+}
+
+// templateParser turns the source of a template into a tree of nodes
+// lenient is true if markers that do not compile have to be kept as literal text
+type templateParser struct {
+	src     string
+	lenient bool
+	nodes   []templateNode
+	blocks  []*block
+}
+
+// parse parses the whole source
+func (p *templateParser) parse() ([]templateNode, error) {
+	last := 0
+	// let's first find all the template markers
+	for _, loc := range findMarkers(p.src) {
+		if loc[0] > last {
+			p.append(templateNode{kind: textNode, text: p.src[last:loc[0]]})
+		}
+		last = loc[1]
+		// content is what's within the brackets
+		content := p.src[loc[2]:loc[3]]
+		trimmed := strings.TrimSpace(content)
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "/") {
+			if keyword, _ := splitDirective(trimmed); p.lenient && !isDirective(keyword) {
+				// in lenient templates, markers such as `${#fff}` are not directives, so they're rendered as they are
+				p.append(templateNode{kind: textNode, text: p.src[loc[0]:loc[1]]})
+				continue
+			}
+			if err := p.parseDirective(trimmed, loc[2]+strings.Index(content, trimmed)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		expression, err := p.compile(content, loc[2])
+		if err != nil && p.lenient {
+			p.append(templateNode{kind: textNode, text: p.src[loc[0]:loc[1]]})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.append(templateNode{kind: exprNode, text: p.src[loc[0]:loc[1]], expression: expression})
+	}
+	if last < len(p.src) {
+		p.append(templateNode{kind: textNode, text: p.src[last:]})
+	}
+	if len(p.blocks) > 0 {
+		open := p.blocks[len(p.blocks)-1]
+		return nil, p.errorAt(open.offset, fmt.Sprintf("unclosed %s", open.keyword))
+	}
+	return p.nodes, nil
+}
+
+// findMarkers finds the `${...}` markers in a template. For each marker, it returns the start and end positions of
+// the whole marker, followed by the start and end positions of its content
+func findMarkers(src string) [][]int {
+	markers := make([][]int, 0)
+	for from := 0; ; {
+		start := strings.Index(src[from:], "${")
+		if start < 0 {
+			return markers
+		}
+		start += from
+		if end := markerEnd(src, start+2); end >= 0 {
+			markers = append(markers, []int{start, end + 1, start + 2, end})
+			from = end + 1
+		} else {
+			from = start + 2
+		}
+	}
+}
+
+// markerEnd returns the position of the bracket closing a marker whose content starts at `from`, or -1 if the marker
+// is not closed. Markers cannot span multiple lines, and a closing bracket within a quoted string does not close the
+// marker
+func markerEnd(src string, from int) int {
+	var quote byte
+	for i := from; i < len(src) && src[i] != '\n'; i++ {
+		switch c := src[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '}':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseDirective parses a block marker, such as `#if condition`. offset is the position of the directive in the
+// template
+func (p *templateParser) parseDirective(directive string, offset int) error {
+	keyword, args := splitDirective(directive)
+	argsOffset := offset + len(directive) - len(args)
+	switch keyword {
+	case "#if":
+		condition, err := p.condition(keyword, args, offset, argsOffset)
+		if err != nil {
+			return err
+		}
+		node := &templateNode{kind: ifNode, branches: []branch{{condition: condition}}}
+		p.blocks = append(p.blocks, &block{node: node, keyword: keyword, offset: offset})
+	case "#elseif":
+		open, err := p.openBlock(keyword, ifNode, offset)
+		if err != nil {
+			return err
+		}
+		if open.hasElse {
+			return p.errorAt(offset, "#elseif after #else")
+		}
+		condition, err := p.condition(keyword, args, offset, argsOffset)
+		if err != nil {
+			return err
+		}
+		open.node.branches = append(open.node.branches, branch{condition: condition})
+	case "#else", "#sep":
+		kind := ifNode
+		if keyword == "#sep" {
+			kind = eachNode
+		}
+		open, err := p.openBlock(keyword, kind, offset)
+		if err != nil {
+			return err
+		}
+		if open.hasElse {
+			return p.errorAt(offset, fmt.Sprintf("duplicate %s", keyword))
+		}
+		if strings.TrimSpace(args) != "" {
+			return p.errorAt(argsOffset, fmt.Sprintf("%s does not take arguments", keyword))
+		}
+		open.hasElse = true
+		open.node.branches = append(open.node.branches, branch{})
+	case "#each":
+		node, err := p.loop(args, offset, argsOffset)
+		if err != nil {
+			return err
+		}
+		p.blocks = append(p.blocks, &block{node: node, keyword: keyword, offset: offset})
+	case "/if", "/each":
+		kind := ifNode
+		if keyword == "/each" {
+			kind = eachNode
+		}
+		open, err := p.openBlock(keyword, kind, offset)
+		if err != nil {
+			return err
+		}
+		p.blocks = p.blocks[:len(p.blocks)-1]
+		p.append(*open.node)
+	default:
+		return p.errorAt(offset, fmt.Sprintf("unknown directive %s", keyword))
+	}
+	return nil
+}
+
+// splitDirective splits a block marker into its keyword and its arguments, which are separated by any whitespace
+func splitDirective(directive string) (string, string) {
+	if idx := strings.IndexFunc(directive, unicode.IsSpace); idx >= 0 {
+		return directive[:idx], directive[idx+1:]
+	}
+	return directive, ""
+}
+
+// isDirective tells whether keyword is the keyword of a block marker
+func isDirective(keyword string) bool {
+	switch keyword {
+	case "#if", "#elseif", "#else", "#each", "#sep", "/if", "/each":
+		return true
+	}
+	return false
+}
+
+// loop parses the arguments of an `#each` directive, that is the collection to iterate and the optional name of the
+// loop variable, as in `items as item`
+func (p *templateParser) loop(args string, offset int, argsOffset int) (*templateNode, error) {
+	if strings.TrimSpace(args) == "" {
+		return nil, p.errorAt(offset, "#each requires a collection")
+	}
+	collection := args
+	variable := ""
+	if idx := strings.LastIndex(args+" ", " as "); idx >= 0 {
+		collection = args[:idx]
+		variable = strings.TrimSpace(args[idx+3:])
+		if !isName(variable) {
+			return nil, p.errorAt(argsOffset+idx+4, "invalid loop variable")
+		}
+	}
+	expression, err := p.compile(collection, argsOffset)
+	if err != nil {
+		return nil, err
+	}
+	return &templateNode{kind: eachNode, expression: expression, variable: variable, branches: []branch{{}}}, nil
+}
+
+// condition compiles the condition of a conditional branch
+func (p *templateParser) condition(keyword string, args string, offset int, argsOffset int) (*Expression, error) {
+	if strings.TrimSpace(args) == "" {
+		return nil, p.errorAt(offset, fmt.Sprintf("%s requires a condition", keyword))
+	}
+	return p.compile(args, argsOffset)
+}
+
+// openBlock returns the innermost open block, so that keyword can be applied to it. The innermost block has to be of
+// the provided kind
+func (p *templateParser) openBlock(keyword string, kind nodeKind, offset int) (*block, error) {
+	if len(p.blocks) == 0 || p.blocks[len(p.blocks)-1].node.kind != kind {
+		return nil, p.errorAt(offset, fmt.Sprintf("unexpected %s", keyword))
+	}
+	return p.blocks[len(p.blocks)-1], nil
+}
+
+// append adds a node to the innermost open block, or to the root of the template
+func (p *templateParser) append(node templateNode) {
+	if len(p.blocks) == 0 {
+		p.nodes = append(p.nodes, node)
+		return
+	}
+	branches := p.blocks[len(p.blocks)-1].node.branches
+	current := &branches[len(branches)-1]
+	current.nodes = append(current.nodes, node)
+}
+
+// compile compiles an expression found at offset in the template. Syntax errors are reported with their position in
+// the template
+func (p *templateParser) compile(expr string, offset int) (*Expression, error) {
+	expression, err := Compile(expr)
+	if err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, p.errorAt(offset+syntaxErr.Column-1, syntaxErr.Msg)
+		}
+		return nil, err
+	}
+	return expression, nil
+}
+
+// errorAt builds a SyntaxError at the given position of the template
+func (p *templateParser) errorAt(offset int, msg string) error {
+	line := strings.Count(p.src[:offset], "\n") + 1
+	column := offset - strings.LastIndex(p.src[:offset], "\n")
+	return &SyntaxError{Expr: p.src, Line: line, Column: column, Msg: msg}
+}
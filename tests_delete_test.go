@@ -0,0 +1,103 @@
+package gowalker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	newData := func() map[string]any {
+		return map[string]any{
+			"name": "Joe",
+			"users": []any{
+				map[string]any{"name": "billy", "password": "secret", "role": "admin"},
+				map[string]any{"name": "john", "password": "secret", "role": "user"},
+				map[string]any{"name": "mary", "role": "user"},
+			},
+			"labels": map[string]string{"app.kubernetes.io/name": "gowalker", "tier": "backend"},
+			"items":  []string{"a", "b", "c"},
+			"index":  1,
+		}
+	}
+	tests := []struct {
+		expr      string
+		container string
+		expected  string
+	}{
+		{"name", "name", `null`},
+		{"items[1]", "items", `["a","c"]`},
+		{"items[-1]", "items", `["a","b"]`},
+		{"items[index]", "items", `["a","c"]`},
+		{"items[*]", "items", `[]`},
+		{"items[?. != 'b']", "items", `["b"]`},
+		{`labels["app.kubernetes.io/name"]`, "labels", `{"tier":"backend"}`},
+		{"labels.*", "labels", `{}`},
+		{"labels.missing", "labels", `{"app.kubernetes.io/name":"gowalker","tier":"backend"}`},
+		{"users[*].password", "users", `[{"name":"billy","role":"admin"},{"name":"john","role":"user"},{"name":"mary","role":"user"}]`},
+		{"users[?role == 'user'].password", "users", `[{"name":"billy","password":"secret","role":"admin"},{"name":"john","role":"user"},{"name":"mary","role":"user"}]`},
+		{"users[?role == 'user']", "users", `[{"name":"billy","password":"secret","role":"admin"}]`},
+		{"users[0]", "users", `[{"name":"john","password":"secret","role":"user"},{"name":"mary","role":"user"}]`},
+		{"users[2].password", "users[2]", `{"name":"mary","role":"user"}`},
+		{"missing.password", "missing", `null`},
+	}
+	for _, test := range tests {
+		data := newData()
+		if err := Delete(ctx, test.expr, data); err != nil {
+			t.Errorf("expression %q: unexpected error %s", test.expr, err)
+			continue
+		}
+		res, _ := Walk(ctx, test.container, data, nil)
+		if js, _ := json.Marshal(res); string(js) != test.expected {
+			t.Errorf("expression %q: expected %s, got %s", test.expr, test.expected, js)
+		}
+	}
+	data := newData()
+	if err := Delete(ctx, "items[3]", data); err != nil || len(data["items"].([]string)) != 3 {
+		t.Error("deleting an index out of bounds should do nothing", err)
+	}
+	if err := Delete(ctx, "items[1:2]", newData()); err == nil {
+		t.Error("deleting a range should fail")
+	}
+	items := []string{"a", "b"}
+	if err := Delete(ctx, "[0]", items); err == nil {
+		t.Error("deleting from a slice that is not passed as a pointer should fail")
+	}
+	if err := Delete(ctx, "[0]", &items); err != nil || len(items) != 1 || items[0] != "b" {
+		t.Error("could not delete from a slice passed as a pointer", err)
+	}
+}
+
+func TestDeleteStructs(t *testing.T) {
+	ctx := context.Background()
+	type user struct {
+		Name     string
+		Password string
+	}
+	type team struct {
+		Users []user
+		Owner *user
+	}
+	tm := team{Users: []user{{"joe", "secret"}, {"pino", "secret"}}, Owner: &user{"joe", "secret"}}
+	if err := Delete(ctx, "Owner.Password", tm); err == nil {
+		t.Error("deleting from a struct that is not passed as a pointer should fail")
+	}
+	if err := Delete(ctx, "Users[*].Password", &tm); err != nil || tm.Users[0].Password != "" || tm.Users[1].Password != "" {
+		t.Error("could not zero struct fields through a wildcard", err)
+	}
+	if err := Delete(ctx, "Owner.Password", &tm); err != nil || tm.Owner.Password != "" || tm.Owner.Name != "joe" {
+		t.Error("could not zero a struct field through a pointer", err)
+	}
+	if err := Delete(ctx, "Users[?Name == 'joe']", &tm); err != nil || len(tm.Users) != 1 || tm.Users[0].Name != "pino" {
+		t.Error("could not remove struct items matching a filter", err)
+	}
+	if err := Delete(ctx, "Owner.Missing", &tm); err == nil {
+		t.Error("deleting a field that does not exist should fail")
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := Delete(cancelled, "Owner", &tm); err == nil {
+		t.Error("deleting should stop when the context is cancelled")
+	}
+}
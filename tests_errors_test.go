@@ -0,0 +1,123 @@
+package gowalker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPathErrors(t *testing.T) {
+	ctx := context.Background()
+	type user struct {
+		Name   string
+		secret string
+	}
+	data := map[string]any{
+		"items": []any{"a", "b"},
+		"index": 9,
+		"user":  user{Name: "joe"},
+	}
+	tests := []struct {
+		expr    string
+		segment string
+		offset  int
+		cause   error
+	}{
+		{"items[3]", "[3]", 5, ErrIndexOutOfBounds},
+		{"items.name", "name", 6, ErrArrayAttribute},
+		{"user.secret", "secret", 5, ErrPrivateField},
+		{"items | nope", "nope", 8, ErrFunctionNotFound},
+		{"items[items[index]]", "[index]", 11, ErrIndexOutOfBounds},
+		{"items[?. == $root.items[7]]", "[7]", 23, ErrIndexOutOfBounds},
+		{"index.split(x)", "split(x)", 6, nil},
+	}
+	for _, test := range tests {
+		_, err := Walk(ctx, test.expr, data, nil)
+		var pathErr *PathError
+		if !errors.As(err, &pathErr) {
+			t.Errorf("expression %q: expected a PathError, got %v", test.expr, err)
+			continue
+		}
+		if pathErr.Expr != test.expr || pathErr.Segment != test.segment || pathErr.Offset != test.offset {
+			t.Errorf("expression %q: unexpected path error %+v", test.expr, pathErr)
+		}
+		if test.cause != nil && !errors.Is(err, test.cause) {
+			t.Errorf("expression %q: expected %v, got %v", test.expr, test.cause, err)
+		}
+	}
+	var pathErr *PathError
+	if _, err := Render(ctx, "hello ${user.Name | nope}", data, nil); !errors.As(err, &pathErr) || pathErr.Expr != "user.Name | nope" {
+		t.Error("a template should report the expression that failed", err)
+	}
+	if err := Set(ctx, "items[5]", data, "c"); !errors.Is(err, ErrIndexOutOfBounds) {
+		t.Error("setting an index out of bounds should return ErrIndexOutOfBounds", err)
+	}
+	if err := Set(ctx, "Name", user{}, "pino"); !errors.Is(err, ErrNotAddressable) {
+		t.Error("setting a value in a struct that is not a pointer should return ErrNotAddressable", err)
+	}
+	var nilMap map[string]any
+	var nilUser *user
+	modifications := []struct {
+		expr    string
+		data    any
+		segment string
+		offset  int
+		cause   error
+	}{
+		{"a.b.c", map[string]any{"a": map[string]any{}}, "b", 2, ErrPathNotFound},
+		{"items[0].name", data, "name", 9, ErrPathNotFound},
+		{"a.b", map[string]any{"a": nilMap}, "b", 2, ErrNilContainer},
+		{"a.Name", map[string]any{"a": nilUser}, "Name", 2, ErrNilContainer},
+		{"user.secret", &data, "secret", 5, ErrPrivateField},
+		{"items[*]", data, "[*]", 5, ErrMultipleValues},
+	}
+	for _, test := range modifications {
+		err := Set(ctx, test.expr, test.data, 1)
+		if !errors.As(err, &pathErr) || pathErr.Expr != test.expr || pathErr.Segment != test.segment ||
+			pathErr.Offset != test.offset || !errors.Is(err, test.cause) {
+			t.Errorf("set %q: unexpected error %v", test.expr, err)
+		}
+	}
+	var keyErr *KeyError
+	if err := Set(ctx, "items.name", data, 1); !errors.As(err, &keyErr) || !errors.As(err, &pathErr) {
+		t.Error("setting a field of an array should return a KeyError", err)
+	}
+	if err := Delete(ctx, "user.secret", &data); !errors.As(err, &pathErr) || !errors.Is(err, ErrPrivateField) {
+		t.Error("deleting a field that does not exist should return ErrPrivateField", err)
+	}
+	functions := map[string]error{
+		"index.size()":          ErrUnsupportedType,
+		"missing.size()":        ErrUnsupportedType,
+		"index.split(x)":        ErrUnsupportedType,
+		"index.jsonEscape()":    ErrUnsupportedType,
+		"items.collect(name)":   ErrUnsupportedType,
+		"index.collect(name)":   ErrNotIterable,
+		"items.collect()":       ErrMissingParameter,
+		"items.render()":        ErrMissingParameter,
+		"items.renderEach()":    ErrMissingParameter,
+		"missing.renderEach(t)": ErrNotIterable,
+	}
+	templates := NewFunctions()
+	templates.addSubTemplates(SubTemplates{"t": "${.}"})
+	for expr, cause := range functions {
+		if _, err := Walk(ctx, expr, data, templates); !errors.As(err, &pathErr) || !errors.Is(err, cause) {
+			t.Errorf("expression %q: expected %v, got %v", expr, cause, err)
+		}
+	}
+}
+
+func TestContextErrors(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Walk(cancelled, "foo", map[string]any{"foo": "bar"}, nil)
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) || err.Error() != "cancelled" {
+		t.Error("a cancelled context should wrap context.Canceled", err)
+	}
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = Render(expired, "${foo}", map[string]any{"foo": "bar"}, nil)
+	if !errors.Is(err, ErrDeadlineExceeded) || !errors.Is(err, context.DeadlineExceeded) || err.Error() != "deadline exceeded" {
+		t.Error("an expired context should wrap context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,124 @@
+package gowalker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	for _, expr := range []string{"", ".", "foo", ".foo", "foo.", "foo.bar[0][1].baz", "[0].foo", "foo.split(\\,).size()", " foo "} {
+		if _, err := Compile(expr); err != nil {
+			t.Errorf("expression %q should compile, got %s", expr, err)
+		}
+	}
+	if expression := MustCompile("foo.bar"); expression.String() != "foo.bar" {
+		t.Error("compiled expression does not retain its source")
+	}
+}
+
+func TestCompileSyntaxErrors(t *testing.T) {
+	tests := map[string]int{
+		"foo...bar":  6,
+		"foo[":       5,
+		"foo[1":      6,
+		"foo.split(": 10,
+		"foo[0]bar":  7,
+		"foo.[0]":    5,
+		"foo)":       4,
+	}
+	for expr, column := range tests {
+		_, err := Compile(expr)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("expression %q should not compile", expr)
+			continue
+		}
+		if syntaxErr.Column != column {
+			t.Errorf("expression %q reported column %d instead of %d", expr, syntaxErr.Column, column)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("MustCompile should panic on a malformed expression")
+		}
+	}()
+	MustCompile("foo[")
+}
+
+func TestExpressionEval(t *testing.T) {
+	ctx := context.Background()
+	expression := MustCompile("items[1].name.size()")
+	data := map[string]any{"items": []any{map[string]any{"name": "foo"}, map[string]any{"name": "banana"}}}
+	for i := 0; i < 2; i++ {
+		if res, err := expression.Eval(ctx, data, nil); res != 6 || err != nil {
+			t.Error("compiled expression did not evaluate as expected")
+		}
+	}
+	if res, _ := MustCompile(".").Eval(ctx, "foo", nil); res != "foo" {
+		t.Error("dot expression should return the whole scope")
+	}
+	if _, err := Walk(ctx, "foo[", data, nil); err == nil {
+		t.Error("Walk should report syntax errors")
+	}
+}
+
+func TestReservedScopes(t *testing.T) {
+	ctx := context.Background()
+	if res, _ := Walk(ctx, "$root.foo", map[string]any{"foo": "bar"}, nil); res != "bar" {
+		t.Error("$root should refer to the data when not rendering a template")
+	}
+	if res, err := Walk(ctx, "$parent.foo", map[string]any{"foo": "bar"}, nil); res != nil || err != nil {
+		t.Error("$parent should be nil when not rendering a template")
+	}
+	for _, expr := range []string{"foo.$root", "foo.$parent", "$root.$parent"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expression %q should not compile", expr)
+		}
+	}
+	if _, err := Compile("$parent.$parent.foo"); err != nil {
+		t.Error("$parent should be allowed to follow $parent")
+	}
+}
+
+func TestPipes(t *testing.T) {
+	ctx := context.Background()
+	functions := NewFunctions()
+	functions.Add("upper", func(ctx context.Context, data any, params ...string) (any, error) {
+		return strings.ToUpper(data.(string)), nil
+	})
+	functions.AddTyped("truncate", func(ctx context.Context, data any, params ...any) (any, error) {
+		s := data.(string)
+		if size := params[0].(int); len(s) > size {
+			return s[:size], nil
+		}
+		return s, nil
+	})
+	data := map[string]any{"user": map[string]any{"name": "billy the kid"}, "size": "not a function", "items": "a|b"}
+	tests := map[string]any{
+		"user.name | upper":                "BILLY THE KID",
+		"user.name|upper|truncate(5)":      "BILLY",
+		"user.name | truncate(5) | size()": 5,
+		". | size":                         3,
+		"size":                             "not a function",
+		"items | split(|) | size":          2,
+		"items | split(|)[1] | upper":      "B",
+	}
+	for expr, expected := range tests {
+		if res, err := Walk(ctx, expr, data, functions); res != expected {
+			t.Errorf("expression %q: expected %v, got %v (%v)", expr, expected, res, err)
+		}
+	}
+	if res, _ := Render(ctx, "Hello ${user.name | upper | truncate(5)}!", data, functions); res != "Hello BILLY!" {
+		t.Error("pipes not working in templates")
+	}
+	if res, err := Walk(ctx, "user.name | missing", data, functions); res != "missing" || err == nil {
+		t.Error("missing function in a pipe should return an error")
+	}
+	for _, expr := range []string{"| size", "foo |", "foo | [0]", "foo. | size"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expression %q should not compile", expr)
+		}
+	}
+}
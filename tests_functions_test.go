@@ -2,19 +2,22 @@ package gowalker
 
 import (
 	"context"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 func TestRunFunction(t *testing.T) {
 	ctx := context.Background()
-	if found, data, err := runFunction(ctx, "split(\\,)", "foo,bar", NewFunctions()); !found || err != nil || data.([]string)[0] != "foo" {
+	expression, _ := Compile("split(\\,)")
+	if data, err := runFunction(ctx, expression.steps[0], "foo,bar", nil, NewFunctions()); err != nil || data.([]string)[0] != "foo" {
 		t.Error("something went wrong while running function")
 	}
-	if found, _, _ := runFunction(ctx, "split(\\,", "foo,bar", NewFunctions()); found {
+	if _, err := Compile("split(\\,"); err == nil {
 		t.Error("a function was found where there was none")
 	}
-	if found, _, _ := runFunction(ctx, "()", "foo,bar", NewFunctions()); found {
+	if _, err := Compile("()"); err == nil {
 		t.Error("a function was found where there was none")
 	}
 }
@@ -26,22 +29,25 @@ func TestPassDotToFunction(t *testing.T) {
 	}
 }
 
-func TestExtractFunctionName(t *testing.T) {
-	if extractFunctionName("foo(bar)") != "foo" {
+func TestCompileFunctionName(t *testing.T) {
+	if expression, _ := Compile("foo(bar)"); expression.steps[0].kind != callStep || expression.steps[0].name != "foo" {
 		t.Error("could not extract function name")
 	}
 }
 
-func TestExtractParameters(t *testing.T) {
-	if extractParameters("foo(bar)")[0] != "bar" {
+func TestCompileParameters(t *testing.T) {
+	if expression, _ := Compile("foo(bar)"); expression.steps[0].params[0].text != "bar" {
 		t.Error("failed at extracting one parameter")
 	}
-	if extractParameters("foo(bar,dawg)")[1] != "dawg" {
+	if expression, _ := Compile("foo(bar,dawg)"); expression.steps[0].params[1].text != "dawg" {
 		t.Error("failed at extracting second parameter")
 	}
-	if extractParameters("foo(bar.dawg)")[0] != "bar.dawg" {
+	if expression, _ := Compile("foo(bar.dawg)"); expression.steps[0].params[0].text != "bar.dawg" {
 		t.Error("failed to extract parameter with dot")
 	}
+	if expression, _ := Compile("foo()"); len(expression.steps[0].params) != 0 {
+		t.Error("empty parentheses should produce no parameters")
+	}
 }
 
 func TestSizeFunction(t *testing.T) {
@@ -145,3 +151,203 @@ func TestJsonEscape(t *testing.T) {
 		t.Error("json escape of a non string should return an error")
 	}
 }
+
+func TestQuotedParameters(t *testing.T) {
+	tests := map[string][]string{
+		`foo("hello world", 'a,b')`:      {"hello world", "a,b"},
+		`foo("#@%()", "ünïcödé")`:        {"#@%()", "ünïcödé"},
+		`foo("say \"hi\"", 'it\'s')`:     {`say "hi"`, "it's"},
+		`foo("a\nb\tc\\d")`:              {"a\nb\tc\\d"},
+		`foo( bar , "baz" )`:             {"bar", "baz"},
+		`foo(bar\,baz)`:                  {"bar,baz"},
+		`foo(bar(baz), "")`:              {"bar(baz)", ""},
+		`foo(22, -1.5, true, null, 1e3)`: {"22", "-1.5", "true", "null", "1e3"},
+	}
+	for expr, expected := range tests {
+		expression, err := Compile(expr)
+		if err != nil {
+			t.Errorf("expression %s should compile, got %s", expr, err)
+			continue
+		}
+		params := expression.steps[0].params
+		if len(params) != len(expected) {
+			t.Errorf("expression %s: expected %d parameters, got %d", expr, len(expected), len(params))
+			continue
+		}
+		for i, p := range params {
+			if p.text != expected[i] {
+				t.Errorf("expression %s: expected parameter %q, got %q", expr, expected[i], p.text)
+			}
+		}
+	}
+	for _, expr := range []string{`foo("bar)`, `foo("bar" baz)`, `foo('bar'`} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expression %s should not compile", expr)
+		}
+	}
+}
+
+func TestTypedParameters(t *testing.T) {
+	ctx := context.Background()
+	functions := NewFunctions()
+	functions.AddTyped("types", func(ctx context.Context, data any, params ...any) (any, error) {
+		res := make([]string, len(params))
+		for i, p := range params {
+			res[i] = "nil"
+			if p != nil {
+				res[i] = reflect.TypeOf(p).String()
+			}
+		}
+		return strings.Join(res, ","), nil
+	})
+	functions.Add("strings", func(ctx context.Context, data any, params ...string) (any, error) {
+		return strings.Join(params, "|"), nil
+	})
+	if res, _ := Walk(ctx, `types("22", 22, 2.5, true, null, bar)`, map[string]any{"bar": []int{}}, functions); res != "string,int,float64,bool,nil,[]int" {
+		t.Errorf("typed parameters not working, got %s", res)
+	}
+	if res, _ := Walk(ctx, `strings("a b", 22, 2.5, false, null, bar)`, nil, functions); res != "a b|22|2.5|false|null|bar" {
+		t.Errorf("string parameters not working, got %s", res)
+	}
+	if res, _ := Render(ctx, `${foo.split("} ")[1]}`, map[string]any{"foo": "a} b"}, functions); res != "b" {
+		t.Errorf("closing bracket in quoted parameter breaks templates, got %s", res)
+	}
+}
+
+func TestPathParameters(t *testing.T) {
+	ctx := context.Background()
+	functions := NewFunctions()
+	functions.AddTyped("multiply", func(ctx context.Context, data any, params ...any) (any, error) {
+		factor, ok := params[0].(int)
+		if !ok {
+			return nil, errors.New("factor is not an integer")
+		}
+		return data.(int) * factor, nil
+	})
+	functions.Add("concat", func(ctx context.Context, data any, params ...string) (any, error) {
+		return data.(string) + strings.Join(params, ""), nil
+	})
+	data := map[string]any{"price": 3, "quantity": 4, "items": []any{"a", "b"}, "sep": "-", "name": "foo"}
+	if res, _ := Walk(ctx, "price.multiply(quantity)", data, functions); res != 12 {
+		t.Error("path parameter not resolved")
+	}
+	if res, _ := Walk(ctx, "price.multiply(items.size())", data, functions); res != 6 {
+		t.Error("function chain parameter not resolved")
+	}
+	if res, _ := Walk(ctx, "price.multiply($root.quantity)", data, functions); res != 12 {
+		t.Error("$root parameter not resolved")
+	}
+	if _, err := Walk(ctx, "price.multiply(missing)", data, functions); err == nil {
+		t.Error("missing path should resolve to nil")
+	}
+	if res, _ := Walk(ctx, "name.concat(sep, $root.sep, \"sep\", $missing)", data, functions); res != "foosep-sep" {
+		t.Errorf("string functions should only resolve $ parameters, got %v", res)
+	}
+	if _, err := Compile("foo.concat($root.[)"); err == nil {
+		t.Error("malformed $ parameter should not compile")
+	}
+	if res, _ := Render(ctx, "${#each items as i}${name.concat($loop.position, i)}${/each}", data, functions); res != "foo1ifoo2i" {
+		t.Errorf("loop metadata not resolved as parameter, got %s", res)
+	}
+	templates := NewSubTemplates()
+	templates.Add("row", "${.}")
+	if res, _ := RenderAll(ctx, "${items.renderEach(row, $root.sep)}", templates, data, functions); res != "a-b" {
+		t.Error("renderEach separator not resolved from $root")
+	}
+	if res, _ := RenderAll(ctx, "${items.renderEach(row, $root.separator)}", templates, data, functions); res != "ab" {
+		t.Errorf("a missing separator should be empty, got %s", res)
+	}
+}
+
+type testPerson struct {
+	First  string
+	Last   string
+	Active bool
+}
+
+func (p testPerson) FullName() string {
+	return p.First + " " + p.Last
+}
+
+func (p testPerson) IsActive() bool {
+	return p.Active
+}
+
+func (p testPerson) Greet(greeting string, times int) string {
+	return strings.Repeat(greeting+" "+p.First+"! ", times)
+}
+
+func (p testPerson) Join(sep string, parts ...string) string {
+	return strings.Join(append([]string{p.First}, parts...), sep)
+}
+
+func (p testPerson) Validate() error {
+	if p.Last == "" {
+		return errors.New("last name is missing")
+	}
+	return nil
+}
+
+func (p *testPerson) Initials() (string, error) {
+	if p.First == "" || p.Last == "" {
+		return "", errors.New("name is incomplete")
+	}
+	return p.First[:1] + p.Last[:1], nil
+}
+
+func (p testPerson) Age(years uint8) uint8 {
+	return years + 1
+}
+
+func (p *testPerson) Rename(first string) string {
+	p.First = first
+	return p.First
+}
+
+func TestMethods(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{"person": testPerson{"joe", "doe", true}, "greeting": "hi", "nobody": &testPerson{}}
+	functions := NewFunctions().Methods(true)
+	tests := map[string]any{
+		"person.FullName()":                 "joe doe",
+		"person | FullName":                 "joe doe",
+		"person.IsActive()":                 true,
+		"person.Greet(hello, 2)":            "hello joe! hello joe! ",
+		"person.Greet($root.greeting, 1.0)": "hi joe! ",
+		"person.Join(-)":                    "joe",
+		"person.Join(-, a, b)":              "joe-a-b",
+		"person.Validate()":                 nil,
+		"person.Initials()":                 "jd",
+		"person.FullName().size()":          7,
+		"person.Age(29)":                    uint8(30),
+	}
+	for expr, expected := range tests {
+		if res, err := Walk(ctx, expr, data, functions); res != expected || err != nil {
+			t.Errorf("expression %q: expected %v, got %v (%v)", expr, expected, res, err)
+		}
+	}
+	for _, expr := range []string{"nobody.Validate()", "nobody.Initials()", "person.Greet(hello)", "person.Greet(hello, many)", "person.Missing()", "person.Age(300)", "person.Age(2.5)"} {
+		if _, err := Walk(ctx, expr, data, functions); err == nil {
+			t.Errorf("expression %q should fail", expr)
+		}
+	}
+	if res, err := Walk(ctx, "person.FullName()", data, nil); res != "FullName()" || err == nil {
+		t.Error("methods should not be invoked unless enabled")
+	}
+	functions.Add("FullName", func(ctx context.Context, scope any, params ...string) (any, error) {
+		return "function", nil
+	})
+	if res, _ := Walk(ctx, "person.FullName()", data, functions); res != "function" {
+		t.Error("functions should take precedence over methods")
+	}
+	if res, _ := Render(ctx, "${person.FullName()} is ${#if person.IsActive()}active${/if}", data, NewFunctions().Methods(true)); res != "joe doe is active" {
+		t.Error("methods should be invoked in templates", res)
+	}
+	person := &testPerson{"joe", "doe", true}
+	if res, err := Walk(ctx, "person.Rename(jane)", map[string]any{"person": &person}, functions); res != "jane" || err != nil {
+		t.Error("error invoking a method on a pointer", res, err)
+	}
+	if person.First != "jane" {
+		t.Error("methods with a pointer receiver should be invoked on the original value, got", person.First)
+	}
+}
@@ -0,0 +1,140 @@
+package gowalker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"name":    "Joe",
+		"friends": []any{map[string]any{"name": "billy"}, map[string]any{"name": "john"}},
+		"labels":  map[string]string{},
+		"scores":  []int{1, 2, 3},
+		"index":   1,
+	}
+	if err := Set(ctx, "name", data, "Pino"); err != nil || data["name"] != "Pino" {
+		t.Error("could not set a map key", err)
+	}
+	if err := Set(ctx, "friends[1].name", data, "mary"); err != nil || data["friends"].([]any)[1].(map[string]any)["name"] != "mary" {
+		t.Error("could not set a map key within an array", err)
+	}
+	if err := Set(ctx, "friends[-1].age", data, 22); err != nil || data["friends"].([]any)[1].(map[string]any)["age"] != 22 {
+		t.Error("could not add a map key with a negative index", err)
+	}
+	if err := Set(ctx, `labels["app.kubernetes.io/name"]`, data, "gowalker"); err != nil || data["labels"].(map[string]string)["app.kubernetes.io/name"] != "gowalker" {
+		t.Error("could not set a quoted key", err)
+	}
+	if err := Set(ctx, "scores[index]", data, 20.0); err != nil || data["scores"].([]int)[1] != 20 {
+		t.Error("could not set an index from a path, converting the value", err)
+	}
+	if err := Set(ctx, "scores[0]", data, "foo"); err == nil {
+		t.Error("setting a value of the wrong type should fail")
+	}
+	if err := Set(ctx, "scores[3]", data, 4); err == nil {
+		t.Error("setting an index out of bounds should fail")
+	}
+	if err := Set(ctx, "missing.name", data, "foo"); err == nil {
+		t.Error("setting a value in a missing container should fail")
+	}
+	if err := Set(ctx, "friends[*].name", data, "foo"); err == nil {
+		t.Error("setting a value at a wildcard should fail")
+	}
+	small := &struct {
+		N uint8
+		I int
+		F float32
+	}{}
+	for _, value := range []any{-1, 256, 2.7, math.NaN()} {
+		if err := Set(ctx, "N", small, value); err == nil || small.N != 0 {
+			t.Errorf("setting %v to an uint8 should fail, got %d", value, small.N)
+		}
+	}
+	if err := Set(ctx, "I", small, 1e20); err == nil || small.I != 0 {
+		t.Error("setting a value that overflows an int should fail")
+	}
+	if err := Set(ctx, "F", small, math.MaxFloat64); err == nil || small.F != 0 {
+		t.Error("setting a value that overflows a float32 should fail")
+	}
+	if err := Set(ctx, "N", small, 255.0); err != nil || small.N != 255 {
+		t.Error("could not set an integral float to an uint8", err)
+	}
+	if err := Set(ctx, "F", small, 2); err != nil || small.F != 2 {
+		t.Error("could not set an integer to a float32", err)
+	}
+}
+
+func TestSetCreate(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{"scores": []int{1}}
+	if err := SetCreate(ctx, "request.headers.accept", data, "application/json"); err != nil {
+		t.Error("could not create intermediate maps", err)
+	}
+	if err := SetCreate(ctx, "request.items[2].name", data, "foo"); err != nil {
+		t.Error("could not create intermediate slices", err)
+	}
+	if err := SetCreate(ctx, "scores[2]", data, 3); err != nil {
+		t.Error("could not grow a slice", err)
+	}
+	expected := `{"request":{"headers":{"accept":"application/json"},"items":[null,null,{"name":"foo"}]},"scores":[1,0,3]}`
+	if js, _ := json.Marshal(data); string(js) != expected {
+		t.Errorf("expected %s, got %s", expected, js)
+	}
+	items := []string{"foo"}
+	if err := SetCreate(ctx, "[1]", items, "bar"); err == nil {
+		t.Error("growing a slice that is not passed as a pointer should fail")
+	}
+	if err := SetCreate(ctx, "[1]", &items, "bar"); err != nil || len(items) != 2 || items[1] != "bar" {
+		t.Error("could not grow a slice passed as a pointer", err)
+	}
+}
+
+func TestSetStructs(t *testing.T) {
+	ctx := context.Background()
+	type address struct {
+		City string
+	}
+	type user struct {
+		Name    string
+		Address address
+		Home    *address
+		Tags    map[string]string
+		private string
+	}
+	u := user{Name: "joe"}
+	if err := Set(ctx, "Name", u, "pino"); err == nil {
+		t.Error("setting a field in a struct that is not passed as a pointer should fail")
+	}
+	if err := Set(ctx, "Address.City", &u, "Rome"); err != nil || u.Address.City != "Rome" {
+		t.Error("could not set a nested struct field", err)
+	}
+	if err := Set(ctx, "Home.City", &u, "Rome"); err == nil {
+		t.Error("setting a field in a nil pointer should fail")
+	}
+	if err := SetCreate(ctx, "Home.City", &u, "Milan"); err != nil || u.Home == nil || u.Home.City != "Milan" {
+		t.Error("could not create a missing pointer", err)
+	}
+	if err := SetCreate(ctx, "Tags.env", &u, "prod"); err != nil || u.Tags["env"] != "prod" {
+		t.Error("could not create a missing typed map", err)
+	}
+	if err := Set(ctx, "private", &u, "foo"); err == nil {
+		t.Error("setting a private field should fail")
+	}
+	users := map[string]user{"joe": u}
+	if err := Set(ctx, "joe.Name", users, "pino"); err != nil || users["joe"].Name != "pino" {
+		t.Error("could not set a field of a struct within a map", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := Set(cancelled, "Name", &u, "foo"); err == nil {
+		t.Error("setting a value should stop when the context is cancelled")
+	}
+	var keyErr *KeyError
+	if err := Set(ctx, "Tags[Tags.none]", &u, "foo"); !errors.As(err, &keyErr) {
+		t.Error("an unusable key should return a KeyError", err)
+	}
+}
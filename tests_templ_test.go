@@ -1,8 +1,11 @@
 package gowalker
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -129,7 +132,7 @@ func TestRenderAllRender(t *testing.T) {
 		t.Error("wrong sub template")
 	}
 
-	if res, err := RenderAll(ctx, t1, nil, map[string]any{"items": []string{"foo", "bar"}}, NewFunctions()); res != "this is a test ${items.render(t2)}" && err.Error() != "template not found" {
+	if res, err := RenderAll(ctx, t1, nil, map[string]any{"items": []string{"foo", "bar"}}, NewFunctions()); res != "this is a test ${items.render(t2)}" && !errors.Is(err, ErrTemplateNotFound) {
 		t.Error("missing sub-template wrong behavior")
 	}
 
@@ -148,7 +151,7 @@ func TestRenderAllRenderEach(t *testing.T) {
 	if res, _ := RenderAll(ctx, t1, templates, map[string]any{"items": []string{"foo", "bar"}}, NewFunctions()); res != "this is a test \nT2 foo,\nT2 bar" {
 		t.Error("renderEach not working as expected")
 	}
-	if res, err := RenderAll(ctx, t1, nil, map[string]any{"items": []string{"foo", "bar"}}, NewFunctions()); res != "this is a test ${items.renderEach(t2,\\,)}" && err.Error() != "template not found" {
+	if res, err := RenderAll(ctx, t1, nil, map[string]any{"items": []string{"foo", "bar"}}, NewFunctions()); res != "this is a test ${items.renderEach(t2,\\,)}" && !errors.Is(err, ErrTemplateNotFound) {
 		t.Error("missing sub-template wrong behavior")
 	}
 
@@ -185,3 +188,365 @@ func TestRenderAgainstStructPointer(t *testing.T) {
 		t.Error("could not render ${.} against struct pointer")
 	}
 }
+
+func TestParseTemplate(t *testing.T) {
+	ctx := context.Background()
+	templ, err := ParseTemplate("name: ${name}, age: ${age}, missing: ${missing}, ${")
+	if err != nil {
+		t.Fatal("template should parse")
+	}
+	if len(templ.nodes) != 7 {
+		t.Error("template not split into literal and expression nodes")
+	}
+	for i := 0; i < 2; i++ {
+		if res, _ := templ.Execute(ctx, map[string]any{"name": "pino", "age": 22}, nil); res != "name: pino, age: 22, missing: ${missing}, ${" {
+			t.Error("parsed template did not execute as expected")
+		}
+	}
+	if _, err := ParseTemplate("foo ${bar[}"); err == nil {
+		t.Error("malformed marker should not parse")
+	}
+	if MustParseTemplate("foo ${bar}").String() != "foo ${bar}" {
+		t.Error("parsed template does not retain its source")
+	}
+}
+
+func TestTemplateExecuteConcurrently(t *testing.T) {
+	templ := MustParseTemplate("${name} is ${age}")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, _ := templ.Execute(context.Background(), map[string]any{"name": "pino", "age": i}, nil)
+			if res != fmt.Sprintf("pino is %d", i) {
+				t.Error("concurrent execution rendered the wrong data")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("write failed")
+}
+
+func TestRenderTo(t *testing.T) {
+	ctx := context.Background()
+	buf := bytes.Buffer{}
+	if err := RenderTo(ctx, &buf, "my name is: ${name}, ${missing}", map[string]any{"name": "pino"}, nil); err != nil || buf.String() != "my name is: pino, ${missing}" {
+		t.Error("could not render to writer")
+	}
+	if err := RenderTo(ctx, &failingWriter{}, "my name is: ${name}", map[string]any{"name": "pino"}, nil); err == nil || err.Error() != "write failed" {
+		t.Error("write errors should be surfaced")
+	}
+	buf.Reset()
+	if err := RenderTo(ctx, &buf, "${foo[} ${name}", map[string]any{"name": "pino"}, nil); err != nil || buf.String() != "${foo[} pino" {
+		t.Error("markers that do not compile should be rendered as they are", err)
+	}
+	if err := RenderTo(ctx, &bytes.Buffer{}, "${#if name}", nil, nil); err == nil {
+		t.Error("malformed template should return an error")
+	}
+}
+
+func TestRenderLiteralMarkers(t *testing.T) {
+	ctx := context.Background()
+	templ := "<script>const first = `${list[i}`;</script> ${name}"
+	if res, err := Render(ctx, templ, map[string]any{"name": "pino"}, nil); err != nil || res != "<script>const first = `${list[i}`;</script> pino" {
+		t.Errorf("markers that do not compile should be rendered as they are, got %s (%v)", res, err)
+	}
+	if _, err := ParseTemplate(templ); err == nil {
+		t.Error("ParseTemplate should reject markers that do not compile")
+	}
+	data := map[string]any{"ok": true}
+	if res, err := Render(ctx, "color ${#fff} ${/path} ok", data, nil); err != nil || res != "color ${#fff} ${/path} ok" {
+		t.Errorf("markers that are not directives should be rendered as they are, got %s (%v)", res, err)
+	}
+	if res, err := Render(ctx, "${#if\tok}yes${/if}", data, nil); err != nil || res != "yes" {
+		t.Errorf("directives should be separated from their arguments by any whitespace, got %s (%v)", res, err)
+	}
+	if _, err := ParseTemplate("color ${#fff}"); err == nil {
+		t.Error("ParseTemplate should reject unknown directives")
+	}
+}
+
+func TestRenderAllTo(t *testing.T) {
+	ctx := context.Background()
+	templates := NewSubTemplates()
+	templates.Add("t2", "\nT2 ${.}")
+	templates.Add("t3", "T3 ${.}")
+	data := map[string]any{"items": []string{"foo", "bar"}}
+
+	buf := bytes.Buffer{}
+	if err := RenderAllTo(ctx, &buf, "test ${items.renderEach(t2,\\,)} ${items[0].render(t3)} ${items.renderEach(t2).size()}", templates, data, NewFunctions()); err != nil || buf.String() != "test \nT2 foo,\nT2 bar T3 foo 14" {
+		t.Error("sub-templates not rendered to writer")
+	}
+	writer := failingWriter{}
+	if err := RenderAllTo(ctx, &writer, "${items.renderEach(t2)}", templates, data, NewFunctions()); err == nil || writer.writes != 1 {
+		t.Error("write errors in sub-templates should be surfaced")
+	}
+	if res, _ := RenderAll(ctx, "${items.renderEach(t2,|)}", templates, data, NewFunctions()); res != "\nT2 foo|\nT2 bar" {
+		t.Error("renderEach not working when rendering to a string")
+	}
+}
+
+func TestRenderConditionals(t *testing.T) {
+	ctx := context.Background()
+	templ := `{"name": "${name}"${#if email}, "email": "${email}"${/if}}`
+	if res, _ := Render(ctx, templ, map[string]any{"name": "pino", "email": "pino@example.com"}, nil); res != `{"name": "pino", "email": "pino@example.com"}` {
+		t.Error("if block not rendered when the condition is true")
+	}
+	if res, _ := Render(ctx, templ, map[string]any{"name": "pino"}, nil); res != `{"name": "pino"}` {
+		t.Error("if block rendered when the condition is false")
+	}
+
+	templ = "${#if a}A${#elseif b}B${#elseif c}C${#else}D${/if}"
+	tests := map[string]map[string]any{
+		"A": {"a": true, "b": true},
+		"B": {"a": false, "b": "yes"},
+		"C": {"c": []int{1}},
+		"D": {"a": "", "b": []string{}, "c": map[string]any{}},
+	}
+	for expected, data := range tests {
+		if res, _ := Render(ctx, templ, data, nil); res != expected {
+			t.Errorf("expected branch %s, got %s", expected, res)
+		}
+	}
+
+	templ = "${#if user}${#if user.admin}admin ${user.name}${#else}user ${user.name}${/if}${#else}anonymous${/if}"
+	if res, _ := Render(ctx, templ, map[string]any{"user": map[string]any{"name": "pino", "admin": true}}, nil); res != "admin pino" {
+		t.Error("nested if blocks not working")
+	}
+	if res, _ := Render(ctx, templ, map[string]any{"user": map[string]any{"name": "pino"}}, nil); res != "user pino" {
+		t.Error("nested if blocks not working")
+	}
+	if res, _ := Render(ctx, templ, map[string]any{}, nil); res != "anonymous" {
+		t.Error("nested if blocks not working")
+	}
+	if res, _ := Render(ctx, "${#if items.size()}has items${/if}", map[string]any{"items": []int{}}, nil); res != "" {
+		t.Error("functions in conditions not working")
+	}
+	if _, err := Render(ctx, "${#if s.privateString}yes${/if}", map[string]any{"s": S{}}, nil); err == nil {
+		t.Error("errors in conditions should be returned")
+	}
+}
+
+func TestParseTemplateBlockErrors(t *testing.T) {
+	tests := map[string]string{
+		"foo ${#if a}bar":                    "syntax error at line 1, column 7: unclosed #if",
+		"foo\n  ${/if}":                      "syntax error at line 2, column 5: unexpected /if",
+		"${#else}":                           "syntax error at line 1, column 3: unexpected #else",
+		"${#if a}${#else}${#else}${/if}":     "syntax error at line 1, column 19: duplicate #else",
+		"${#if a}${#else}${#elseif b}${/if}": "syntax error at line 1, column 19: #elseif after #else",
+		"${#if}${/if}":                       "syntax error at line 1, column 3: #if requires a condition",
+		"${#if a[}${/if}":                    "syntax error at line 1, column 9: expected array index",
+		"${#else a}":                         "syntax error at line 1, column 3: unexpected #else",
+		"${#foo}":                            "syntax error at line 1, column 3: unknown directive #foo",
+		"line1\n${foo[0]bar}":                "syntax error at line 2, column 9: expected '.' or '[', found 'b'",
+	}
+	for templ, expected := range tests {
+		if _, err := ParseTemplate(templ); err == nil || err.Error() != expected {
+			t.Errorf("template %q: expected error %q, got %v", templ, expected, err)
+		}
+	}
+}
+
+func TestRenderLoops(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"title":   "friends",
+		"friends": []any{map[string]any{"name": "billy", "age": 27}, map[string]any{"name": "john", "age": 23}},
+		"ages":    [2]int{27, 23},
+		"scores":  map[string]int{"john": 5, "billy": 3},
+		"user":    struct{ Name, Email string }{"pino", "pino@example.com"},
+		"empty":   []string{},
+	}
+	tests := map[string]string{
+		"${#each friends as f}${f.name} in ${title}${#sep}, ${/each}":     "billy in friends, john in friends",
+		"${#each friends}${name}(${age})${/each}":                         "billy(27)john(23)",
+		"${#each ages as a}${a}${#sep}|${/each}":                          "27|23",
+		"${#each scores as s}${s.key}=${s.value}${#sep},${/each}":         "billy=3,john=5",
+		"${#each user}${key}:${value} ${/each}":                           "Name:pino Email:pino@example.com ",
+		"[${#each empty as e}${e}${/each}]":                               "[]",
+		"[${#each missing as e}${e}${/each}]":                             "[]",
+		"${#each friends as f}${#each f as p}${p.key}${/each};${/each}":   "agename;agename;",
+		"${#each friends as f}${#if f.age.eq(23)}${f.name}${/if}${/each}": "john",
+		"${#each friends as f}${size()}${/each}":                          "66",
+	}
+	for templ, expected := range tests {
+		if res, err := Render(ctx, templ, data, nil); res != expected {
+			t.Errorf("template %q: expected %q, got %q (%v)", templ, expected, res, err)
+		}
+	}
+	if _, err := Render(ctx, "${#each title as t}${t}${/each}", data, nil); err == nil {
+		t.Error("iterating a string should return an error")
+	}
+}
+
+func TestParseTemplateLoopErrors(t *testing.T) {
+	tests := map[string]string{
+		"${#each}${/each}":                     "syntax error at line 1, column 3: #each requires a collection",
+		"${#each items as}${/each}":            "syntax error at line 1, column 18: invalid loop variable",
+		"${#each items as a.b}${/each}":        "syntax error at line 1, column 18: invalid loop variable",
+		"${#each items}":                       "syntax error at line 1, column 3: unclosed #each",
+		"${#each items}${/if}":                 "syntax error at line 1, column 17: unexpected /if",
+		"${#if items}${#sep}${/if}":            "syntax error at line 1, column 15: unexpected #sep",
+		"${#each items}${#sep}${#sep}${/each}": "syntax error at line 1, column 24: duplicate #sep",
+		"${#each items}${#if a}${/each}${/if}": "syntax error at line 1, column 25: unexpected /each",
+	}
+	for templ, expected := range tests {
+		if _, err := ParseTemplate(templ); err == nil || err.Error() != expected {
+			t.Errorf("template %q: expected error %q, got %v", templ, expected, err)
+		}
+	}
+}
+
+func TestRenderEachLoopMetadata(t *testing.T) {
+	ctx := context.Background()
+	templates := NewSubTemplates()
+	templates.Add("row", "${$loop.position}/${$loop.length} ${.}${#if $loop.last}.${#else}, ${/if}")
+	templates.Add("first", "${#if $loop.first}[${/if}${$loop.index}:${$loop.key}=${value}")
+	data := map[string]any{"items": []string{"foo", "bar", "baz"}, "scores": map[string]int{"john": 5, "billy": 3}}
+	if res, _ := RenderAll(ctx, "${items.renderEach(row)}", templates, data, NewFunctions()); res != "1/3 foo, 2/3 bar, 3/3 baz." {
+		t.Error("loop metadata not available in renderEach")
+	}
+	if res, _ := RenderAll(ctx, "${scores.renderEach(first)}", templates, data, NewFunctions()); res != "[0:billy=31:john=5" {
+		t.Error("loop metadata not available in renderEach over maps")
+	}
+	if res, _ := Render(ctx, "${#each items as i}${$loop.position}.${i}${#if $loop.last}!${/if} ${/each}", data, nil); res != "1.foo 2.bar 3.baz! " {
+		t.Error("loop metadata not available in loop blocks")
+	}
+}
+
+func TestRenderRootAndParentScopes(t *testing.T) {
+	ctx := context.Background()
+	templates := NewSubTemplates()
+	templates.Add("order", "${id}: ${lines.renderEach(line,\\,)}")
+	templates.Add("line", "${qty} x ${price} ${$root.currency} (order ${$parent.id}, ${$parent.$parent.currency})")
+	data := map[string]any{
+		"currency": "EUR",
+		"orders": []any{
+			map[string]any{"id": "A", "lines": []any{map[string]any{"qty": 1, "price": 2.5}, map[string]any{"qty": 2, "price": 3}}},
+		},
+	}
+	if res, err := RenderAll(ctx, "${orders.renderEach(order)}", templates, data, NewFunctions()); res != "A: 1 x 2.5 EUR (order A, EUR),2 x 3 EUR (order A, EUR)" {
+		t.Errorf("root and parent scopes not available in sub-templates: %s %v", res, err)
+	}
+	if res, _ := Render(ctx, "${#each orders}${#each lines}${qty}${$root.currency}${$parent.id}${/each}${/each}", data, nil); res != "1EURA2EURA" {
+		t.Error("root and parent scopes not available in loop blocks")
+	}
+	if res, _ := Render(ctx, "${$root.currency}${$parent}", data, nil); res != "EUR${$parent}" {
+		t.Error("root and parent scopes not working in the outermost template")
+	}
+}
+
+func TestRenderLazyValues(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	orders := 0
+	data := map[string]any{
+		"user": profileResolver{&calls},
+		"orders": func(_ context.Context) (any, error) {
+			orders++
+			return []any{map[string]any{"id": 1}}, nil
+		},
+		"account": map[string]any{"owner": func(_ context.Context) (any, error) {
+			return "joe", nil
+		}},
+		"expensive": func(_ context.Context) (any, error) {
+			return nil, errors.New("should not be resolved")
+		},
+	}
+	templates := NewSubTemplates()
+	templates.Add("card", "${$root.user.name}")
+	tpl := "${user.name}${#if user.name} ${user.render(card)}${/if} ${orders[0].id}${orders.size()}"
+	if res, err := RenderAll(ctx, tpl, templates, data, NewFunctions()); err != nil || res != "joe joe 11" {
+		t.Errorf("could not render lazy values, got %s %v", res, err)
+	}
+	if calls != 1 {
+		t.Errorf("resolvers should be resolved once per render, resolved %d times", calls)
+	}
+	if orders != 1 {
+		t.Errorf("functions should be called once per render, called %d times", orders)
+	}
+	if _, err := Render(ctx, "${user.name}", data, nil); err != nil || calls != 2 {
+		t.Error("lazy values should be resolved again in a new render", err)
+	}
+	if res, err := Render(ctx, "${account} ${orders}", data, nil); err != nil || res != `{"owner":"joe"} [{"id":1}]` {
+		t.Errorf("lazy values within rendered containers should be resolved, got %s %v", res, err)
+	}
+	users := 0
+	fetch := func(_ context.Context) (any, error) {
+		users++
+		return map[string]any{"name": "joe", "email": "joe@example.com"}, nil
+	}
+	tpl = "${user.name} ${user.email} ${#if user.name}x${/if}"
+	if res, err := Render(ctx, tpl, map[string]any{"user": fetch}, nil); err != nil || res != "joe joe@example.com x" || users != 1 {
+		t.Errorf("functions should be called once per render, got %s %v, called %d times", res, err, users)
+	}
+	users = 0
+	tpl = "${#each users}${name}${email}${/each}${users[*].name}"
+	if res, err := Render(ctx, tpl, map[string]any{"users": []any{fetch}}, nil); err != nil || users != 1 {
+		t.Errorf("items should be resolved once per render, got %s %v, called %d times", res, err, users)
+	}
+	users = 0
+	if _, err := Render(ctx, "${User.name}${User.email}", &struct{ User any }{fetch}, nil); err != nil || users != 1 {
+		t.Error("the fields of structs behind a pointer should be resolved once per render", err, users)
+	}
+	invalid := map[string]any{"user": struct{ Fetch func() }{}}
+	if _, err := Render(ctx, "${user}", invalid, nil); err == nil {
+		t.Error("data that cannot be rendered as JSON should return an error")
+	}
+}
+
+func TestRenderAllParsesSubTemplatesOnce(t *testing.T) {
+	ctx := context.Background()
+	functions := NewFunctions()
+	templates := SubTemplates{"row": "<${.}>"}
+	if res, _ := RenderAll(ctx, "${items.renderEach(row)}", templates, map[string]any{"items": []string{"a", "b"}}, functions); res != "<a><b>" {
+		t.Errorf("could not render sub-templates, got %s", res)
+	}
+	first, _ := functions.subTemplate("row")
+	if second, _ := functions.subTemplate("row"); first == nil || first != second {
+		t.Error("registered sub-templates should be parsed once")
+	}
+	functions.GetScope()["_row"] = "[${.}]"
+	if res, _ := Render(ctx, "${items.renderEach(row)}", map[string]any{"items": []string{"a"}}, functions); res != "[a]" {
+		t.Errorf("a sub-template changed in the scope should be parsed again, got %s", res)
+	}
+	buf := bytes.Buffer{}
+	if err := RenderAllTo(ctx, &buf, "${x.render(a)}", SubTemplates{"a": "b"}, map[string]any{"x": 1}, nil); err != nil || buf.String() != "b" {
+		t.Error("RenderAllTo should accept nil functions", err)
+	}
+	if res, err := RenderAll(ctx, "${x.render(a)}", SubTemplates{"a": "b"}, map[string]any{"x": 1}, nil); err != nil || res != "b" {
+		t.Error("RenderAll should accept nil functions", err)
+	}
+}
+
+func TestRenderProjections(t *testing.T) {
+	ctx := context.Background()
+	templates := SubTemplates{"t": "(${n})"}
+	data := map[string]any{"items": []any{map[string]any{"n": "a"}, map[string]any{"n": "b", "x": map[string]any{"n": "c"}}}}
+	tests := map[string]string{
+		"${items[*].render(t)}":              `["(a)","(b)"]`,
+		"${items[?n == 'a'].render(t)}":      `["(a)"]`,
+		"${..x.render(t)}":                   `["(c)"]`,
+		"${items[*] | renderEach(t)}":        `(a)(b)`,
+		"${items[*].render(t) | size()}":     `2`,
+		"${items[*].n}${items[0].render(t)}": `["a","b"](a)`,
+	}
+	for templ, expected := range tests {
+		buf := bytes.Buffer{}
+		if err := RenderAllTo(ctx, &buf, templ, templates, data, nil); err != nil || buf.String() != expected {
+			t.Errorf("template %q: expected %s, got %s (%v)", templ, expected, buf.String(), err)
+		}
+		if res, err := RenderAll(ctx, templ, templates, data, nil); err != nil || res != expected {
+			t.Errorf("template %q: expected %s, got %s (%v)", templ, expected, res, err)
+		}
+	}
+}
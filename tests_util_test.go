@@ -1,26 +1,45 @@
 package gowalker
 
 import (
+	"context"
+	"errors"
 	"math"
 	"os"
+	"reflect"
 	"testing"
 )
 
 func TestConvertDataToString(t *testing.T) {
-	var i int = 22
-	if convertDataToString(i) != "22" {
-		t.Error("could not convert int")
+	ctx := context.Background()
+	type color string
+	tests := []struct {
+		data     any
+		expected string
+	}{
+		{int(22), "22"},
+		{int64(22), "22"},
+		{float32(22.5), "22.5"},
+		{nil, "null"},
+		{uint8(200), "200"},
+		{int32(-12), "-12"},
+		{color("red"), "red"},
+		{[2]int{1, 2}, "[1,2]"},
+		{[]byte("hi"), `"aGk="`},
+		{map[string]any{"id": 1, "owner": func(_ context.Context) (any, error) { return "joe", nil }}, `{"id":1,"owner":"joe"}`},
 	}
-	var i64 int64 = 22
-	if convertDataToString(i64) != "22" {
-		t.Error("could not convert int64")
+	for _, test := range tests {
+		if res, err := convertDataToString(ctx, test.data); err != nil || res != test.expected {
+			t.Errorf("could not convert %T: expected %s, got %s (%v)", test.data, test.expected, res, err)
+		}
 	}
-	var f32 float32 = 22.5
-	if convertDataToString(f32) != "22.5" {
-		t.Error("could not convert float32")
+	cyclic := map[string]any{}
+	cyclic["self"] = cyclic
+	if _, err := convertDataToString(ctx, cyclic); err == nil {
+		t.Error("converting a cyclic data structure should fail")
 	}
-	if convertDataToString(nil) != "null" {
-		t.Error("could not convert nil value")
+	broken := []any{func(_ context.Context) (any, error) { return nil, errors.New("unavailable") }}
+	if _, err := convertDataToString(ctx, broken); err == nil || err.Error() != "unavailable" {
+		t.Error("the error of a lazy value should be returned", err)
 	}
 }
 func TestConvertStringToSameType(t *testing.T) {
@@ -29,17 +48,28 @@ func TestConvertStringToSameType(t *testing.T) {
 		t.Error("could not convert int")
 	}
 	var i64 int64 = 22
-	if res, _ := convertStringToSameType(i64, "37"); res != 37 {
+	if res, _ := convertStringToSameType(i64, "37"); res != int64(37) {
 		t.Error("could not convert int64")
 	}
 	var f32 float32 = 22.5
-	if res, _ := convertStringToSameType(f32, "50.7"); math.Floor(res.(float64)*100)/100 != 50.7 {
+	if res, _ := convertStringToSameType(f32, "50.7"); res != float32(50.7) {
 		t.Error("could not convert float32")
 	}
 	var f64 float64 = 22.5
 	if res, _ := convertStringToSameType(f64, "50.7"); math.Floor(res.(float64)*100)/100 != 50.7 {
 		t.Error("could not convert float32")
 	}
+	var u8 uint8 = 1
+	if res, _ := convertStringToSameType(u8, "200"); res != uint8(200) {
+		t.Error("could not convert uint8")
+	}
+	if _, err := convertStringToSameType(u8, "300"); err == nil {
+		t.Error("converting a value that overflows the type should fail")
+	}
+	type color string
+	if res, _ := convertStringToSameType(color("red"), "blue"); res != color("blue") {
+		t.Error("could not convert a named string")
+	}
 	if res, _ := convertStringToSameType(nil, "bananas"); res != "bananas" {
 		t.Error("could not convert nil value")
 	}
@@ -64,3 +94,50 @@ func TestLoadTemplatesFromDisk(t *testing.T) {
 		t.Error("could not load sub template")
 	}
 }
+
+func TestIsTruthy(t *testing.T) {
+	str := "foo"
+	var nilPtr *string
+	for _, v := range []any{true, 1, uint8(1), -2.5, "foo", []int{1}, map[string]int{"a": 1}, [1]int{}, &str, S{}} {
+		if !isTruthy(v) {
+			t.Errorf("%v should be truthy", v)
+		}
+	}
+	for _, v := range []any{nil, false, 0, uint(0), 0.0, "", []int{}, map[string]int{}, nilPtr} {
+		if isTruthy(v) {
+			t.Errorf("%v should be falsy", v)
+		}
+	}
+}
+
+func TestConvertKey(t *testing.T) {
+	tests := []struct {
+		key      any
+		sample   any
+		expected any
+	}{
+		{"foo", "", "foo"},
+		{1, "", "1"},
+		{1.5, "", "1.5"},
+		{"2", 0, 2},
+		{2.0, int8(0), int8(2)},
+		{"7", uint(0), uint(7)},
+		{3, 0.0, 3.0},
+		{"true", false, true},
+		{"foo", any(nil), "foo"},
+	}
+	for _, test := range tests {
+		typ := reflect.TypeOf(test.sample)
+		if test.sample == nil {
+			typ = reflect.TypeOf((*any)(nil)).Elem()
+		}
+		if res, ok := convertKey(test.key, typ); !ok || res.Interface() != test.expected {
+			t.Errorf("could not convert %v (%T) to %s", test.key, test.key, typ)
+		}
+	}
+	for _, key := range []any{nil, "foo", 1.5, 300, -1, true} {
+		if _, ok := convertKey(key, reflect.TypeOf(uint8(0))); ok {
+			t.Errorf("%v should not be converted to uint8", key)
+		}
+	}
+}
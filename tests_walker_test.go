@@ -3,6 +3,8 @@ package gowalker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -76,21 +78,110 @@ func TestWalkWithNestedTypes(t *testing.T) {
 	}
 }
 
-func TestExtractIndex(t *testing.T) {
-	if partial, index := extractIndexes("foo[0]"); partial != "foo" || index[0] != 0 {
+func TestCompileIndexes(t *testing.T) {
+	if expression, _ := Compile("foo[0]"); expression.steps[0].name != "foo" || expression.steps[1].index != 0 {
 		t.Error("could not extract 1 digit index or partial")
 	}
-	if partial, index := extractIndexes("foo[29]"); partial != "foo" || index[0] != 29 {
+	if expression, _ := Compile("foo[29]"); expression.steps[0].name != "foo" || expression.steps[1].index != 29 {
 		t.Error("could not extract 2 digits index or partial")
 	}
-	if partial, index := extractIndexes("foo[]"); partial != "foo[]" || index != nil {
+	if _, err := Compile("foo[]"); err == nil {
 		t.Error("error parsing empty square brackets")
 	}
-	if partial, index := extractIndexes("foo"); partial != "foo" || index != nil {
+	if expression, _ := Compile("foo"); len(expression.steps) != 1 || expression.steps[0].kind != fieldStep {
 		t.Error("could not extract no index partial")
 	}
-	if partial, _ := extractIndexes("foo[bar]"); partial != "foo[bar]" {
-		t.Error("an index with alpha characters should be parsed as a segment")
+	if expression, _ := Compile("foo[bar]"); expression.steps[1].kind != keyStep || expression.steps[1].path.source != "bar" {
+		t.Error("an index with alpha characters should be a path")
+	}
+	if expression, _ := Compile("foo[0][1]"); expression.steps[1].index != 0 || expression.steps[2].index != 1 {
+		t.Error("could not extract nested indexes")
+	}
+}
+
+func TestWalkFreeTextKeys(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"first name": "pino",
+		"user":       map[string]any{"last name": "rossi", "a*b": 1},
+		"ratings":    map[string]any{"5*": 3, "4*": 2},
+	}
+	tests := map[string]any{
+		"first name":            "pino",
+		" first name ":          "pino",
+		"user.last name":        "rossi",
+		"user.a*b":              1,
+		"ratings.5*":            3,
+		"first name | toString": "pino",
+		"user.* | size()":       2,
+	}
+	for expr, expected := range tests {
+		if res, err := Walk(ctx, expr, data, nil); err != nil || res != expected {
+			t.Errorf("expression %q: expected %v, got %v (%v)", expr, expected, res, err)
+		}
+	}
+}
+
+func TestWalkNegativeIndexesAndSlices(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{"items": []string{"a", "b", "c", "d", "e"}}
+	if res, _ := Walk(ctx, "items[-1]", data, nil); res != "e" {
+		t.Error("negative index not working")
+	}
+	if res, _ := Walk(ctx, "items[-5]", data, nil); res != "a" {
+		t.Error("negative index at the start of the array not working")
+	}
+	if _, err := Walk(ctx, "items[-6]", data, nil); !errors.Is(err, ErrIndexOutOfBounds) || err.Error() != "[-6] at column 6 of items[-6]: index out of bounds" {
+		t.Error("negative index exceeding the array should be out of bounds")
+	}
+	slices := map[string][]string{
+		"items[1:3]":      {"b", "c"},
+		"items[:2]":       {"a", "b"},
+		"items[3:]":       {"d", "e"},
+		"items[:]":        {"a", "b", "c", "d", "e"},
+		"items[-2:]":      {"d", "e"},
+		"items[:-3]":      {"a", "b"},
+		"items[::2]":      {"a", "c", "e"},
+		"items[1::2]":     {"b", "d"},
+		"items[::-1]":     {"e", "d", "c", "b", "a"},
+		"items[3:0:-1]":   {"d", "c", "b"},
+		"items[-1:-3:-1]": {"e", "d"},
+		"items[2:100]":    {"c", "d", "e"},
+		"items[-100:2]":   {"a", "b"},
+		"items[4:1]":      {},
+		"items[10:]":      {},
+		"items[ 1 : 3 ]":  {"b", "c"},
+	}
+	for expr, expected := range slices {
+		res, err := Walk(ctx, expr, data, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", expr, err)
+			continue
+		}
+		if items, ok := res.([]string); !ok || len(items) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", expr, expected, res)
+		} else {
+			for i := range items {
+				if items[i] != expected[i] {
+					t.Errorf("%s: expected %v, got %v", expr, expected, res)
+					break
+				}
+			}
+		}
+	}
+	if res, _ := Walk(ctx, "items[1:3][-1]", data, nil); res != "c" {
+		t.Error("index after a slice not working")
+	}
+	if res, _ := Walk(ctx, "items[1:3] | size", data, NewFunctions()); res != 2 {
+		t.Error("function after a slice not working")
+	}
+	if res, _ := Walk(ctx, "name[0:1]", map[string]any{"name": "foo"}, nil); res != nil {
+		t.Error("slicing something that is not an array should be nil")
+	}
+	for _, expr := range []string{"items[::0]", "items[1:2:3:4]", "items[-]", "items[1:a]", "items[:"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("%s should not compile", expr)
+		}
 	}
 }
 
@@ -239,3 +330,576 @@ func TestWalkWithNilReference(t *testing.T) {
 		t.Error("access to a private field should return an error")
 	}
 }
+
+func TestWalkWildcards(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"friends": []any{
+			map[string]any{"name": "billy", "tags": []any{"a", "b"}, "pets": []any{map[string]any{"name": "rex"}}},
+			map[string]any{"name": "john", "tags": []any{"c"}, "pets": []any{map[string]any{"name": "tom"}, map[string]any{"name": "felix"}}},
+			map[string]any{"tags": []any{}},
+		},
+		"settings": map[string]any{
+			"email": map[string]any{"enabled": true},
+			"sms":   map[string]any{"enabled": false},
+			"push":  map[string]any{},
+		},
+		"matrix": [][]int{{1, 2}, {3}},
+		"struct": struct{ A, B string }{"a", "b"},
+	}
+	tests := map[string]string{
+		"friends[*].name":             `["billy","john"]`,
+		"friends.*.name":              `["billy","john"]`,
+		"friends[*].tags":             `[["a","b"],["c"],[]]`,
+		"friends[*].tags[*]":          `["a","b","c"]`,
+		"friends[*].pets[*].name":     `["rex","tom","felix"]`,
+		"friends[*].tags[0]":          `null`,
+		"settings.*.enabled":          `[true,false]`,
+		"settings[*].enabled":         `[true,false]`,
+		"matrix[*][*]":                `[1,2,3]`,
+		"struct.*":                    `["a","b"]`,
+		"friends[*].tags.size()":      `[2,1,0]`,
+		"friends[*].name | size":      `2`,
+		"friends[*].pets[*] | size()": `3`,
+		"missing[*].name":             `null`,
+		"friends[0].name.*":           `null`,
+	}
+	for expr, expected := range tests {
+		res, err := Walk(ctx, expr, data, nil)
+		if expected == "null" && err != nil {
+			continue
+		}
+		if js, _ := json.Marshal(res); string(js) != expected {
+			t.Errorf("expression %q: expected %s, got %s (%v)", expr, expected, js, err)
+		}
+	}
+	for _, expr := range []string{"friends[*", "friends[0]*", "friends[0] *"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expression %q should not compile", expr)
+		}
+	}
+}
+
+func TestWalkFilters(t *testing.T) {
+	ctx := context.Background()
+	type pet struct {
+		Name string
+		Age  int
+	}
+	data := map[string]any{
+		"friends": []any{
+			map[string]any{"name": "billy", "age": 27, "active": true, "tags": []any{"a", "b"}},
+			map[string]any{"name": "john", "age": 23, "active": false, "tags": []any{"c"}},
+			map[string]any{"name": "mary", "age": 31.5, "tags": []any{}},
+		},
+		"pets":    []pet{{"rex", 3}, {"tom", 7}},
+		"numbers": []int{1, 5, 10},
+		"minAge":  25,
+	}
+	tests := map[string]string{
+		"friends[?age > 25].name":                      `["billy","mary"]`,
+		"friends[?name == 'john'].age":                 `[23]`,
+		`friends[?name == "john"].age | size`:          `1`,
+		"friends[?name != 'john'].name":                `["billy","mary"]`,
+		"friends[?age >= 27 && age < 30].name":         `["billy"]`,
+		"friends[?age < 24 || name == 'mary'].name":    `["john","mary"]`,
+		"friends[?!(age < 24 || name == 'mary')].name": `["billy"]`,
+		"friends[?active].name":                        `["billy"]`,
+		"friends[?!active].name":                       `["john","mary"]`,
+		"friends[?active == false].name":               `["john"]`,
+		"friends[?missing == null].name":               `["billy","john","mary"]`,
+		"friends[?tags.size() > 1].name":               `["billy"]`,
+		"friends[?tags[?. == 'c']].name":               `["john"]`,
+		"friends[?age > $root.minAge].name":            `["billy","mary"]`,
+		"friends[?name > 'j'].name":                    `["john","mary"]`,
+		"friends[?name > 3].name":                      `[]`,
+		"friends[?age==23].name":                       `["john"]`,
+		"friends[?age > 25][0]":                        `[]`,
+		"pets[?Age > 5].Name":                          `["tom"]`,
+		"numbers[?. >= 5]":                             `[5,10]`,
+		"numbers[?. > 100]":                            `[]`,
+		"minAge[?. > 1]":                               `null`,
+	}
+	for expr, expected := range tests {
+		res, err := Walk(ctx, expr, data, nil)
+		if expected == "null" && err != nil {
+			continue
+		}
+		if js, _ := json.Marshal(res); string(js) != expected {
+			t.Errorf("expression %q: expected %s, got %s (%v)", expr, expected, js, err)
+		}
+	}
+	if _, err := Walk(ctx, "friends[?tags.name == 'a']", data, nil); err == nil {
+		t.Error("errors in the predicate should be returned")
+	}
+	for _, expr := range []string{"friends[?]", "friends[?age >]", "friends[?age > 25", "friends[?(age > 25]",
+		"friends[?name == 'john]", "friends[?age > 1 2]", "friends[?a.$root]"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expression %q should not compile", expr)
+		}
+	}
+}
+
+func TestWalkRecursiveDescent(t *testing.T) {
+	ctx := context.Background()
+	type node struct {
+		ID       int
+		Children []*node
+	}
+	data := map[string]any{
+		"id": 1,
+		"categories": []any{
+			map[string]any{"id": 2, "children": []any{map[string]any{"id": 3}, map[string]any{"id": 4}}},
+			map[string]any{"id": 5, "name": "five"},
+		},
+		"tree": &node{ID: 6, Children: []*node{{ID: 7}, {ID: 8, Children: []*node{{ID: 9}}}}},
+	}
+	tests := map[string]string{
+		"..id":                    `[3,4,2,5,1]`,
+		"categories..id":          `[3,4,2,5]`,
+		"categories[0]..id":       `[3,4,2]`,
+		"..ID":                    `[6,7,8,9]`,
+		"tree..ID":                `[6,7,8,9]`,
+		"..name":                  `["five"]`,
+		"..children[*].id":        `[3,4]`,
+		"..children[0].id":        `[3]`,
+		"..id | size":             `5`,
+		"categories..missing":     `[]`,
+		"categories[?id > 2]..id": `[5]`,
+	}
+	for expr, expected := range tests {
+		res, err := Walk(ctx, expr, data, nil)
+		if js, _ := json.Marshal(res); string(js) != expected {
+			t.Errorf("expression %q: expected %s, got %s (%v)", expr, expected, js, err)
+		}
+	}
+	cyclic := map[string]any{"id": 1}
+	cyclic["self"] = cyclic
+	if res, _ := Walk(ctx, "..id", cyclic, nil); len(res.([]any)) != 1 {
+		t.Error("cyclic data should be visited once")
+	}
+	shared := map[string]any{"id": 1}
+	data = map[string]any{"a": shared, "b": shared, "c": []any{shared}}
+	if res, _ := Walk(ctx, "..id", data, nil); !reflect.DeepEqual(res, []any{1, 1, 1}) {
+		t.Error("shared data should be visited each time it's reached", res)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := Walk(cancelled, "..id", data, nil); err == nil || err.Error() != "cancelled" {
+		t.Error("recursive descent should stop when the context is cancelled")
+	}
+	for _, expr := range []string{"..", "foo..", "..[0]", "..*", "..size()", "...id"} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expression %q should not compile", expr)
+		}
+	}
+}
+
+func TestWalkQuotedKeys(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"labels":    map[string]string{"app.kubernetes.io/name": "gowalker", "tier": "backend"},
+		"headers":   map[string]any{"Content-Type": "text/plain", "it's": "quoted", `say "hi"`: "hi", "a[0]": "bracket"},
+		"items":     []any{map[string]any{"my key": "first"}},
+		"plain key": "root",
+	}
+	tests := map[string]any{
+		`labels["app.kubernetes.io/name"]`: "gowalker",
+		`labels['tier']`:                   "backend",
+		`labels[ "tier" ]`:                 "backend",
+		`headers['Content-Type']`:          "text/plain",
+		`headers['it\'s']`:                 "quoted",
+		`headers["say \"hi\""]`:            "hi",
+		`headers["a[0]"]`:                  "bracket",
+		`items[0]["my key"]`:               "first",
+		`["plain key"]`:                    "root",
+		`labels["missing"]`:                nil,
+		`labels["tier"] | size`:            7,
+	}
+	for expr, expected := range tests {
+		if res, err := Walk(ctx, expr, data, nil); res != expected {
+			t.Errorf("expression %q: expected %v, got %v (%v)", expr, expected, res, err)
+		}
+	}
+	if res, _ := Walk(ctx, `headers[?. == 'x']`, data, nil); res != nil {
+		t.Error("quoted keys should not interfere with filters")
+	}
+	for _, expr := range []string{`labels["tier"`, `labels["tier]`, `labels["tier" "app"]`} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("expression %q should not compile", expr)
+		}
+	}
+}
+
+func TestWalkPathIndexes(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"items":        []string{"foo", "bar", "baz"},
+		"selected":     1,
+		"last":         -1.0,
+		"lang":         "it",
+		"translations": map[string]string{"en": "hello", "it": "ciao"},
+		"codes":        map[int]string{1: "one", 2: "two"},
+		"codeKey":      "2",
+		"order":        []any{2, 0},
+		"user":         struct{ Name string }{"pino"},
+		"field":        "Name",
+		"fraction":     1.5,
+	}
+	tests := map[string]any{
+		"items[selected]":                 "bar",
+		"items[ selected ]":               "bar",
+		"items[last]":                     "baz",
+		"items[order[0]]":                 "baz",
+		"translations[lang]":              "ciao",
+		"translations[$root.lang]":        "ciao",
+		`translations[lang] | size`:       4,
+		"codes[selected]":                 "one",
+		"codes[codeKey]":                  "two",
+		"user[field]":                     "pino",
+		"translations[translations.none]": nil,
+	}
+	for expr, expected := range tests {
+		res, err := Walk(ctx, expr, data, nil)
+		if expected == nil && err != nil {
+			continue
+		}
+		if res != expected {
+			t.Errorf("expression %q: expected %v, got %v (%v)", expr, expected, res, err)
+		}
+	}
+	var keyErr *KeyError
+	for _, expr := range []string{"items[lang]", "items[fraction]", "items[missing]", "codes[lang]", "user[selected]"} {
+		if _, err := Walk(ctx, expr, data, nil); !errors.As(err, &keyErr) {
+			t.Errorf("expression %q should return a KeyError, got %v", expr, err)
+		}
+	}
+	if _, err := Walk(ctx, "items[missing]", data, nil); !errors.As(err, &keyErr) || keyErr.Error() != "invalid key [missing]: resolved to nil" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+	if _, err := Walk(ctx, "items[lang]", data, nil); !errors.As(err, &keyErr) || keyErr.Error() != "invalid key [lang] = it: array index is not an integer" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+	if _, err := Walk(ctx, "items[selected + 1]", data, nil); err == nil {
+		t.Error("a malformed path in square brackets should not compile")
+	}
+}
+
+func TestWalkJSONTags(t *testing.T) {
+	ctx := context.Background()
+	type Audit struct {
+		CreatedBy string `json:"created_by"`
+	}
+	type Address struct {
+		City string `json:"city"`
+	}
+	type user struct {
+		Audit
+		Address  `json:"address"`
+		Name     string `json:"first_name,omitempty"`
+		Surname  string
+		Password string `json:"-"`
+		Age      int    `json:",omitempty"`
+	}
+	u := user{Audit{"admin"}, Address{"Rome"}, "joe", "doe", "secret", 22}
+	tests := map[string]any{
+		"first_name":        "joe",
+		"Name":              "joe",
+		"Surname":           "doe",
+		"Age":               22,
+		"created_by":        "admin",
+		"address.city":      "Rome",
+		"Address.City":      "Rome",
+		"Password":          "secret",
+		"..city":            []any{"Rome"},
+		"..City":            []any{"Rome"},
+		"..Name":            []any{"joe"},
+		"..Address":         []any{Address{"Rome"}},
+		"*":                 []any{"admin", Address{"Rome"}, "joe", "doe", 22},
+		"first_name.size()": 3,
+	}
+	for expr, expected := range tests {
+		res, _ := Walk(ctx, expr, u, nil)
+		js, _ := json.Marshal(res)
+		if exp, _ := json.Marshal(expected); string(js) != string(exp) {
+			t.Errorf("expression %q: expected %v, got %v", expr, expected, res)
+		}
+	}
+	if _, err := Walk(ctx, "city", u, nil); err == nil {
+		t.Error("fields of embedded structs with a tag name should not be promoted")
+	}
+	if _, err := Walk(ctx, "first_name", u, NewFunctions().JSONTags(false)); err == nil {
+		t.Error("json tags should not be used when disabled")
+	}
+	if res, _ := Render(ctx, "${#each . as f}${f.key} ${/each}", u, nil); res != "created_by address first_name Surname Age " {
+		t.Errorf("struct iteration should use json tag names, got %s", res)
+	}
+	if err := Set(ctx, "address.city", &u, "Milan"); err != nil || u.City != "Milan" {
+		t.Error("could not set a field by its json tag name", err)
+	}
+}
+
+func TestWalkZeroValues(t *testing.T) {
+	ctx := context.Background()
+	data := map[string]any{
+		"counts": map[string]int{"zero": 0, "one": 1},
+		"flags":  map[string]bool{"off": false},
+		"names":  map[string]string{"empty": ""},
+	}
+	tests := map[string]any{
+		"counts.zero":         0,
+		"counts.one":          1,
+		"flags.off":           false,
+		"names.empty":         "",
+		"counts[names.empty]": nil,
+		"flags.off.eq(false)": true,
+	}
+	for expr, expected := range tests {
+		if res, _ := Walk(ctx, expr, data, nil); res != expected {
+			t.Errorf("expression %q: expected %v, got %v", expr, expected, res)
+		}
+	}
+	if res, _ := Render(ctx, "${counts.zero} ${flags.off} [${names.empty}]", data, nil); res != "0 false []" {
+		t.Errorf("zero values should be rendered, got %s", res)
+	}
+}
+
+func TestWalkKinds(t *testing.T) {
+	ctx := context.Background()
+	type color string
+	data := map[string]any{
+		"grid":   [2][3]int{{1, 2, 3}, {4, 5, 6}},
+		"rows":   [][2]string{{"a", "b"}, {"c", "d"}},
+		"codes":  map[int]string{1: "one", 2: "two"},
+		"flags":  map[bool]string{true: "yes"},
+		"colors": map[color]uint8{"red": 200},
+		"small":  int32(-12),
+		"ratio":  float32(0.5),
+		"named":  color("red"),
+		"people": [2]map[string]any{{"name": "joe", "age": uint8(22)}, {"name": "mary", "age": uint8(30)}},
+	}
+	tests := map[string]any{
+		"grid[1][2]":              6,
+		"grid[-1][0]":             4,
+		"rows[1][0]":              "c",
+		"codes.1":                 "one",
+		"codes[2]":                "two",
+		"codes.foo":               nil,
+		"flags.true":              "yes",
+		"colors.red":              uint8(200),
+		"colors.red.eq(200)":      true,
+		"small.eq(-12)":           true,
+		"ratio.eq(0.5)":           true,
+		"named.eq(red)":           true,
+		"grid.size()":             2,
+		"people[?age > 25].name":  []any{"mary"},
+		"people[?name == named]":  []any{},
+		"people[0].age.eq(22)":    true,
+		"people.collect(name)[1]": map[string]any{"name": "mary"},
+	}
+	for expr, expected := range tests {
+		res, err := Walk(ctx, expr, data, nil)
+		if err != nil {
+			t.Errorf("expression %q: unexpected error %s", expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, expected) {
+			t.Errorf("expression %q: expected %#v, got %#v", expr, expected, res)
+		}
+	}
+	if res, _ := Walk(ctx, "grid[0][1:]", data, nil); !reflect.DeepEqual(res, []int{2, 3}) {
+		t.Errorf("could not slice a Go array, got %#v", res)
+	}
+	if res, _ := Render(ctx, "${small} ${ratio} ${colors.red} ${named} ${grid[0]}", data, nil); res != "-12 0.5 200 red [1,2,3]" {
+		t.Errorf("could not render numeric kinds, got %s", res)
+	}
+}
+
+// orderedMap is a Walkable keeping its keys in insertion order
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newOrderedMap(pairs ...any) *orderedMap {
+	m := &orderedMap{values: map[string]any{}}
+	for i := 0; i < len(pairs); i += 2 {
+		m.keys = append(m.keys, pairs[i].(string))
+		m.values[pairs[i].(string)] = pairs[i+1]
+	}
+	return m
+}
+
+func (m *orderedMap) WalkField(_ context.Context, name string) (any, bool, error) {
+	val, ok := m.values[name]
+	return val, ok, nil
+}
+
+func (m *orderedMap) WalkIndex(_ context.Context, i int) (any, bool, error) {
+	return m.values[m.keys[i]], true, nil
+}
+
+func (m *orderedMap) Len() int {
+	return len(m.keys)
+}
+
+func TestWalkable(t *testing.T) {
+	ctx := context.Background()
+	users := newOrderedMap(
+		"zoe", newOrderedMap("name", "zoe", "age", 31),
+		"adam", newOrderedMap("name", "adam", "age", 22),
+	)
+	data := map[string]any{"users": users, "key": "adam", "first": 0}
+	tests := map[string]any{
+		"users.zoe.age":         31,
+		"users.bob":             nil,
+		"users[1].name":         "adam",
+		"users[-2].name":        "zoe",
+		"users[key].age":        22,
+		"users[first].name":     "zoe",
+		"users[*].name":         []any{"zoe", "adam"},
+		"users[?age > 25].name": []any{"zoe"},
+		"users[1:][0].name":     "adam",
+		"..age":                 []any{31, 22},
+		"users.size()":          2,
+		"users.collect(name)":   []map[string]any{{"name": "zoe"}, {"name": "adam"}},
+	}
+	for expr, expected := range tests {
+		res, err := Walk(ctx, expr, data, nil)
+		if err != nil {
+			t.Errorf("expression %q: unexpected error %s", expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, expected) {
+			t.Errorf("expression %q: expected %#v, got %#v", expr, expected, res)
+		}
+	}
+	if _, err := Walk(ctx, "users[2]", data, nil); err == nil {
+		t.Error("an index out of bounds should return an error")
+	}
+	if res, _ := Lookup(ctx, "users.bob", data, nil); res.Found {
+		t.Error("a missing member should not be found")
+	}
+	if res, _ := Lookup(ctx, "users.zoe", data, nil); !res.Found {
+		t.Error("an existing member should be found")
+	}
+	if res, _ := Render(ctx, "${#each users as u}${$loop.index}:${u.name} ${/each}", data, nil); res != "0:zoe 1:adam " {
+		t.Errorf("could not iterate a Walkable, got %s", res)
+	}
+	templates := NewSubTemplates()
+	templates.Add("user", "${name}")
+	if res, _ := RenderAll(ctx, "${users.renderEach(user,\\,)}", templates, data, NewFunctions()); res != "zoe,adam" {
+		t.Errorf("could not renderEach a Walkable, got %s", res)
+	}
+}
+
+// profileResolver is a Resolver counting how many times it's resolved
+type profileResolver struct {
+	calls *int
+}
+
+func (r profileResolver) Resolve(_ context.Context) (any, error) {
+	*r.calls++
+	return map[string]any{"name": "joe"}, nil
+}
+
+func TestWalkLazyValues(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	data := map[string]any{
+		"profile": profileResolver{&calls},
+		"orders": func(_ context.Context) (any, error) {
+			calls++
+			return []any{map[string]any{"id": 1}, map[string]any{"id": 2}}, nil
+		},
+		"broken": func(_ context.Context) (any, error) {
+			return nil, errors.New("unavailable")
+		},
+		"nested": func(_ context.Context) (any, error) {
+			return profileResolver{&calls}, nil
+		},
+	}
+	tests := map[string]any{
+		"profile.name":    "joe",
+		"orders[1].id":    2,
+		"orders[*].id":    []any{1, 2},
+		"orders.size()":   2,
+		"nested.name":     "joe",
+		"orders..id":      []any{1, 2},
+		"profile.missing": nil,
+	}
+	for expr, expected := range tests {
+		res, err := Walk(ctx, expr, data, nil)
+		if err != nil {
+			t.Errorf("expression %q: unexpected error %s", expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, expected) {
+			t.Errorf("expression %q: expected %#v, got %#v", expr, expected, res)
+		}
+	}
+	calls = 0
+	if _, err := Walk(ctx, "name", data, nil); err != nil || calls != 0 {
+		t.Error("lazy values should only be resolved when reached", err)
+	}
+	if _, err := Walk(ctx, "broken.foo", data, nil); err == nil || err.Error() != "unavailable" {
+		t.Error("the error of a lazy value should be returned", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+	type user struct {
+		Name  string
+		Email *string
+	}
+	data := map[string]any{
+		"present": nil,
+		"zero":    0,
+		"items":   []any{nil, "foo"},
+		"labels":  map[string]string{"tier": ""},
+		"user":    user{Name: "joe"},
+		"key":     "tier",
+	}
+	tests := map[string]WalkResult{
+		"present":        {nil, true},
+		"missing":        {nil, false},
+		"zero":           {0, true},
+		"items[0]":       {nil, true},
+		"items[-2]":      {nil, true},
+		"labels.tier":    {"", true},
+		"labels[key]":    {"", true},
+		"labels.app":     {nil, false},
+		"present.foo":    {nil, false},
+		"missing.foo":    {nil, false},
+		"user.Email":     {nil, true},
+		"user.Name":      {"joe", true},
+		"toVar(missing)": {nil, true},
+		".":              {data, true},
+	}
+	for expr, expected := range tests {
+		res, err := Lookup(ctx, expr, data, nil)
+		if err != nil {
+			t.Errorf("expression %q: unexpected error %s", expr, err)
+			continue
+		}
+		if res.Found != expected.Found || (expr != "." && res.Value != expected.Value) {
+			t.Errorf("expression %q: expected %v, got %v", expr, expected, res)
+		}
+	}
+	if res, err := Lookup(ctx, "items[5]", data, nil); err != nil || res.Found {
+		t.Error("an index out of bounds should not be found", res, err)
+	}
+	calls := 0
+	functions := NewFunctions()
+	functions.Add("count", func(ctx context.Context, scope any, params ...string) (any, error) {
+		calls++
+		return scope, nil
+	})
+	if res, err := Lookup(ctx, "present.count().foo", data, functions); err != nil || res.Found || calls != 1 {
+		t.Error("functions along the path should run once", res, err, calls)
+	}
+	if _, err := Lookup(ctx, "items[", data, nil); err == nil {
+		t.Error("a malformed expression should return an error")
+	}
+}
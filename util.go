@@ -1,40 +1,59 @@
 package gowalker
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
-// convertDataToString converts the provided data into a string for the template
-func convertDataToString(data any) string {
+// convertDataToString converts the provided data into a string for the template. Will return an error if data cannot
+// be rendered as JSON, or if one of the lazy values within it fails to resolve
+func convertDataToString(ctx context.Context, data any) (string, error) {
 	if data == nil {
-		return "null"
+		return "null", nil
 	}
-	switch reflect.TypeOf(data).Kind() {
-	case reflect.Int, reflect.Int64:
-		return fmt.Sprintf("%d", data)
+	val := reflect.ValueOf(data)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(val.Uint(), 10), nil
 	case reflect.Float32:
-		return strconv.FormatFloat(float64(data.(float32)), 'f', -1, 64)
+		return strconv.FormatFloat(val.Float(), 'f', -1, 32), nil
 	case reflect.Float64:
-		return strconv.FormatFloat(data.(float64), 'f', -1, 64)
+		return strconv.FormatFloat(val.Float(), 'f', -1, 64), nil
 	case reflect.Bool:
-		return strconv.FormatBool(data.(bool))
-	case reflect.Slice, reflect.Map, reflect.Struct:
-		// Slices and maps are rendered as JSON
-		d, _ := json.Marshal(data)
-		return string(d)
-	case reflect.Pointer:
-		t := reflect.ValueOf(data).Elem().Interface()
-		return convertDataToString(t)
+		return strconv.FormatBool(val.Bool()), nil
+	case reflect.String:
+		return val.String(), nil
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		// Slices and maps are rendered as JSON, with the lazy values they contain resolved
+		resolved, err := resolveNested(ctx, data, map[uintptr]bool{})
+		if err != nil {
+			return "", err
+		}
+		d, err := json.Marshal(resolved)
+		if err != nil {
+			return "", err
+		}
+		return string(d), nil
+	case reflect.Pointer, reflect.Interface:
+		if val.IsNil() {
+			return "null", nil
+		}
+		return convertDataToString(ctx, val.Elem().Interface())
 	}
-	return data.(string)
+	return fmt.Sprint(data), nil
 }
 
 // convertStringToSameType tries to convert val to the same type of sample
@@ -42,18 +61,409 @@ func convertStringToSameType(sample any, val string) (any, error) {
 	if sample == nil {
 		return val, errors.New("sample is nil")
 	}
-	switch reflect.TypeOf(sample).Kind() {
-	case reflect.Int, reflect.Int64:
-		return strconv.Atoi(val)
-	case reflect.Float32:
-		return strconv.ParseFloat(val, 32)
-	case reflect.Float64:
-		return strconv.ParseFloat(val, 64)
+	t := reflect.TypeOf(sample)
+	res := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(val, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		res.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(val, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		res.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		res.SetFloat(f)
 	case reflect.Bool:
-		return strconv.ParseBool(val)
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, err
+		}
+		res.SetBool(b)
+	case reflect.String:
+		res.SetString(val)
 	default:
 		return val, nil
 	}
+	return res.Interface(), nil
+}
+
+// isTruthy tells whether data should be considered true in a condition. nil, false, zeroes, and empty strings, arrays
+// and maps are false. Everything else is true
+func isTruthy(data any) bool {
+	if data == nil {
+		return false
+	}
+	val := reflect.ValueOf(data)
+	switch val.Kind() {
+	case reflect.Bool:
+		return val.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return val.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return val.Float() != 0
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return val.Len() > 0
+	case reflect.Pointer, reflect.Interface:
+		return !val.IsNil() && isTruthy(val.Elem().Interface())
+	}
+	return true
+}
+
+// forEach calls fn for each item in data, which can be a slice, an array, a map, a struct or a Walkable. size is the
+// number of items. Maps and structs are iterated in key order, and their items are key/value maps, as in
+// `{"key": "name", "value": "pino"}`. key is the map key or field name, and it's nil for arrays and Walkables. With
+// tags, fields are named after their json tag
+func forEach(ctx context.Context, data any, tags bool, fn func(i int, size int, key any, item any) error) error {
+	container := holder(dereference(data), data)
+	data = dereference(data)
+	if w, ok := data.(Walkable); ok {
+		items, err := walkableItems(ctx, w)
+		if err != nil {
+			return err
+		}
+		for i, item := range items {
+			if err := fn(i, len(items), nil, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	val := reflect.ValueOf(data)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if err := fn(i, val.Len(), nil, locate(val.Index(i).Interface(), container, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := sortedKeys(val)
+		for i, key := range keys {
+			value := locate(val.MapIndex(key).Interface(), container, key.Interface())
+			entry := map[string]any{"key": key.Interface(), "value": value}
+			if err := fn(i, len(keys), key.Interface(), entry); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := structFields(val.Type(), tags)
+		for i, field := range fields {
+			value := locate(val.FieldByIndex(field.index).Interface(), container, field.name)
+			entry := map[string]any{"key": field.name, "value": value}
+			if err := fn(i, len(fields), field.name, entry); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrNotIterable
+	}
+	return nil
+}
+
+// values returns the items of a slice or an array, or the values of a map or a struct, in key order. The second
+// return value is false if data is none of those. With tags, struct fields skipped by encoding/json are skipped
+func values(data any, tags bool) ([]any, bool) {
+	val := reflect.ValueOf(dereference(data))
+	container := holder(dereference(data), data)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Array:
+		res := make([]any, val.Len())
+		for i := range res {
+			res[i] = locate(val.Index(i).Interface(), container, i)
+		}
+		return res, true
+	case reflect.Map:
+		keys := sortedKeys(val)
+		res := make([]any, len(keys))
+		for i, key := range keys {
+			res[i] = locate(val.MapIndex(key).Interface(), container, key.Interface())
+		}
+		return res, true
+	case reflect.Struct:
+		fields := structFields(val.Type(), tags)
+		res := make([]any, len(fields))
+		for i, field := range fields {
+			res[i] = locate(val.FieldByIndex(field.index).Interface(), container, field.name)
+		}
+		return res, true
+	}
+	return nil, false
+}
+
+// toFloat returns the value of a number of any kind as a float64. The second return value is false if data is not a
+// number
+func toFloat(data any) (float64, bool) {
+	val := reflect.ValueOf(data)
+	switch {
+	case val.CanInt():
+		return float64(val.Int()), true
+	case val.CanUint():
+		return float64(val.Uint()), true
+	case val.CanFloat():
+		return val.Float(), true
+	}
+	return 0, false
+}
+
+// toString returns the value of data if it's a string, or any type based on string
+func toString(data any) (string, bool) {
+	val := reflect.ValueOf(data)
+	if val.Kind() != reflect.String {
+		return "", false
+	}
+	return val.String(), true
+}
+
+// toInt returns data as an int. The second return value is false if data is not an integral number
+func toInt(data any) (int, bool) {
+	val := reflect.ValueOf(data)
+	switch {
+	case val.CanInt():
+		return int(val.Int()), true
+	case val.CanUint():
+		return int(val.Uint()), true
+	case val.CanFloat() && val.Float() == math.Trunc(val.Float()):
+		return int(val.Float()), true
+	}
+	return 0, false
+}
+
+// convertNumber converts the number val to the numeric type t. The second return value is false if t cannot represent
+// val, as when it overflows t, or when it has a fractional part and t is an integer type
+func convertNumber(val reflect.Value, t reflect.Type) (reflect.Value, bool) {
+	res := reflect.New(t).Elem()
+	fits := false
+	switch {
+	case res.CanInt():
+		switch {
+		case val.CanInt():
+			fits = !res.OverflowInt(val.Int())
+		case val.CanUint():
+			fits = val.Uint() <= math.MaxInt64 && !res.OverflowInt(int64(val.Uint()))
+		default:
+			f := val.Float()
+			fits = f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 && !res.OverflowInt(int64(f))
+		}
+	case res.CanUint():
+		switch {
+		case val.CanInt():
+			fits = val.Int() >= 0 && !res.OverflowUint(uint64(val.Int()))
+		case val.CanUint():
+			fits = !res.OverflowUint(val.Uint())
+		default:
+			f := val.Float()
+			fits = f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 && !res.OverflowUint(uint64(f))
+		}
+	case res.CanFloat():
+		// integers always fit, at most losing precision, as they do in Go
+		fits = !val.CanFloat() || math.IsInf(val.Float(), 0) || !res.OverflowFloat(val.Float())
+	}
+	if !fits {
+		return res, false
+	}
+	res.Set(val.Convert(t))
+	return res, true
+}
+
+// convertKey converts key to the type t, so that it can be used to look up a map whose keys are of that type.
+// Numbers and strings are converted to each other. The second return value is false if key cannot be converted
+func convertKey(key any, t reflect.Type) (reflect.Value, bool) {
+	val := reflect.ValueOf(key)
+	if !val.IsValid() {
+		return val, false
+	}
+	if val.Type().AssignableTo(t) {
+		return val, true
+	}
+	res := reflect.New(t).Elem()
+	text, isText := key.(string)
+	switch t.Kind() {
+	case reflect.String:
+		if _, ok := toFloat(key); !ok && val.Kind() != reflect.String {
+			return res, false
+		}
+		res.SetString(fmt.Sprint(key))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := toInt(key)
+		if isText {
+			parsed, err := strconv.ParseInt(text, 10, 64)
+			i, ok = int(parsed), err == nil
+		}
+		if !ok || res.OverflowInt(int64(i)) {
+			return res, false
+		}
+		res.SetInt(int64(i))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := toInt(key)
+		if isText {
+			parsed, err := strconv.ParseUint(text, 10, 64)
+			i, ok = int(parsed), err == nil
+		}
+		if !ok || i < 0 || res.OverflowUint(uint64(i)) {
+			return res, false
+		}
+		res.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(key)
+		if isText {
+			parsed, err := strconv.ParseFloat(text, 64)
+			f, ok = parsed, err == nil
+		}
+		if !ok {
+			return res, false
+		}
+		res.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if !isText || err != nil {
+			return res, false
+		}
+		res.SetBool(b)
+	default:
+		return res, false
+	}
+	return res, true
+}
+
+// structField is an exported field of a struct
+// name is the name the field is selected by
+// index is the index sequence of the field, for reflect.Value.FieldByIndex
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields returns the exported fields of a struct type, including the ones promoted from embedded structs.
+// With tags, fields are named after their json tag, as encoding/json does: fields tagged `-` are skipped, and embedded
+// structs with a tag name are fields themselves, rather than having their fields promoted
+func structFields(t reflect.Type, tags bool) []structField {
+	key := fieldsKey{t: t, tags: tags}
+	if fields, ok := fieldsCache.Load(key); ok {
+		return fields.([]structField)
+	}
+	visible := reflect.VisibleFields(t)
+	// hidden are the embedded structs whose fields are not promoted
+	hidden := make([][]int, 0)
+	for _, field := range visible {
+		if name := jsonName(field); tags && field.Anonymous && name != "" {
+			hidden = append(hidden, field.Index)
+		}
+	}
+	fields := make([]structField, 0)
+	for _, field := range visible {
+		if !field.IsExported() || isPromotedFrom(field.Index, hidden) {
+			continue
+		}
+		name := field.Name
+		if tags {
+			if tag := jsonName(field); tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			} else if field.Anonymous {
+				continue
+			}
+		} else if field.Anonymous {
+			continue
+		}
+		fields = append(fields, structField{name: name, index: field.Index})
+	}
+	fieldsCache.Store(key, fields)
+	return fields
+}
+
+// fieldsKey is the key of the fields cache
+type fieldsKey struct {
+	t    reflect.Type
+	tags bool
+}
+
+// fieldsCache caches the fields of the struct types, as computing them at every access is expensive
+var fieldsCache sync.Map
+
+// findField returns the exported field of a struct type selected by name. With tags, json tag names take precedence
+// over Go names
+func findField(t reflect.Type, name string, tags bool) (structField, bool) {
+	if tags {
+		for _, field := range structFields(t, true) {
+			if field.name == name {
+				return field, true
+			}
+		}
+	}
+	if field, ok := t.FieldByName(name); ok && field.IsExported() {
+		return structField{name: field.Name, index: field.Index}, true
+	}
+	return structField{}, false
+}
+
+// jsonName returns the name in the json tag of a field, which is `-` for fields encoding/json skips, or an empty
+// string if the tag does not name the field
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// isPromotedFrom returns true if the field with the given index sequence belongs to one of the embedded structs
+func isPromotedFrom(index []int, embedded [][]int) bool {
+	for _, parent := range embedded {
+		if len(index) > len(parent) && reflect.DeepEqual(index[:len(parent)], parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopVariable is the name of the variable holding the metadata of the current iteration of a loop
+const loopVariable = "$loop"
+
+// withLoop returns a scope in which the metadata of the i-th iteration of a loop is available as `$loop`, alongside
+// scope. The metadata are the 0-based index, the 1-based position, whether the iteration is the first or the last,
+// the length of the collection and, for maps and structs, the key
+func withLoop(scope any, i int, size int, key any) variableScope {
+	meta := map[string]any{"index": i, "position": i + 1, "first": i == 0, "last": i == size-1, "length": size}
+	if key != nil {
+		meta["key"] = key
+	}
+	return variableScope{name: loopVariable, value: meta, outer: scope}
+}
+
+// sortedKeys returns the keys of a map, sorted. Keys are compared by their string representation, unless they're
+// numbers
+func sortedKeys(val reflect.Value) []reflect.Value {
+	keys := val.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Kind() == reflect.Interface {
+			a, b = a.Elem(), b.Elem()
+		}
+		// nil keys come first
+		if !a.IsValid() || !b.IsValid() {
+			return !a.IsValid() && b.IsValid()
+		}
+		if a.CanInt() && b.CanInt() {
+			return a.Int() < b.Int()
+		}
+		if a.CanUint() && b.CanUint() {
+			return a.Uint() < b.Uint()
+		}
+		if a.CanFloat() && b.CanFloat() {
+			return a.Float() < b.Float()
+		}
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	})
+	return keys
 }
 
 func LoadTemplatesFromDisk(filePath string) (string, SubTemplates, error) {
@@ -0,0 +1,130 @@
+package gowalker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Walkable is implemented by custom containers that reflection cannot navigate, such as ordered maps, lazily loaded
+// records or caches. The walker checks for it before using reflection, so these types can be used in paths, iterated
+// by loop blocks and `renderEach`, measured by `size()` and collected by `collect()`
+type Walkable interface {
+	// WalkField returns the member named `name`. The second return value is false if there is no such member
+	WalkField(ctx context.Context, name string) (any, bool, error)
+	// WalkIndex returns the item at position i, with 0 <= i < Len(). The second return value is false if there is no
+	// such item
+	WalkIndex(ctx context.Context, i int) (any, bool, error)
+	// Len returns the number of items
+	Len() int
+}
+
+// walkWalkable applies the first step to a Walkable, as walkImpl does with reflection, and walks the rest of the steps
+// against the result
+func walkWalkable(ctx context.Context, steps []step, w Walkable, scope any, functions *Functions) (any, error) {
+	current := steps[0]
+	var res any
+	var err error
+	switch current.kind {
+	case wildcardStep, filterStep, sliceStep:
+		items, err := walkableItems(ctx, w)
+		if err != nil {
+			return nil, stepError(current, err)
+		}
+		switch current.kind {
+		case wildcardStep:
+			return project(ctx, steps, items, scope, functions)
+		case filterStep:
+			items, _, err = filter(ctx, current.filter, items, scope, functions)
+			if err != nil {
+				return nil, stepError(current, err)
+			}
+			return project(ctx, steps, items, scope, functions)
+		}
+		res = selectRange(items, current.bounds)
+	case descentStep:
+		items, err := descend(ctx, w, current.name, functions.jsonTags, map[uintptr]bool{}, make([]any, 0))
+		if err != nil {
+			return nil, stepError(current, err)
+		}
+		return project(ctx, steps, items, scope, functions)
+	case indexStep:
+		res, err = walkableIndex(ctx, w, current.index)
+	case keyStep:
+		res, err = selectKey(ctx, current.path, w, scope, functions)
+	default:
+		res, _, err = w.WalkField(ctx, current.name)
+	}
+	if err != nil {
+		return nil, stepError(current, err)
+	}
+	return walkImpl(ctx, steps[1:], res, scope, functions)
+}
+
+// descendWalkable appends to res the member named `name` of a Walkable, if any, and then descends into its items. As
+// with maps, a Walkable behind a pointer is not descended into again within itself
+func descendWalkable(ctx context.Context, w Walkable, name string, tags bool, visited map[uintptr]bool,
+	res []any) ([]any, error) {
+	if val := reflect.ValueOf(w); val.Kind() == reflect.Pointer {
+		if visited[val.Pointer()] {
+			return res, nil
+		}
+		visited[val.Pointer()] = true
+		defer delete(visited, val.Pointer())
+	}
+	item, found, err := w.WalkField(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		res = append(res, item)
+	}
+	items, err := walkableItems(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if res, err = descend(ctx, item, name, tags, visited, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// walkableIndex selects the item at position `index` in a Walkable. Negative indexes count from the end
+func walkableIndex(ctx context.Context, w Walkable, index int) (any, error) {
+	if index < 0 {
+		index += w.Len()
+	}
+	if index < 0 || index >= w.Len() {
+		return nil, ErrIndexOutOfBounds
+	}
+	res, _, err := w.WalkIndex(ctx, index)
+	return res, err
+}
+
+// walkableKey selects a member of a Walkable by a key resolved from a path. Integral keys select items by position,
+// and any other key selects a member by its string representation
+func walkableKey(ctx context.Context, w Walkable, key any) (any, error) {
+	if index, ok := toInt(key); ok {
+		return walkableIndex(ctx, w, index)
+	}
+	res, _, err := w.WalkField(ctx, fmt.Sprint(key))
+	return res, err
+}
+
+// walkableItems returns all the items of a Walkable, in order. Missing items are nil
+func walkableItems(ctx context.Context, w Walkable) ([]any, error) {
+	res := make([]any, w.Len())
+	for i := range res {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		item, _, err := w.WalkIndex(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = item
+	}
+	return res, nil
+}
@@ -2,225 +2,571 @@ package gowalker
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"reflect"
-	"strconv"
-	"strings"
 	"time"
 )
 
 // Walk "walks" the provided data using the provided expression
 func Walk(ctx context.Context, expr string, data any, functions *Functions) (any, error) {
-	if functions == nil {
-		functions = NewFunctions()
+	expression, err := Compile(expr)
+	if err != nil {
+		return nil, err
 	}
-	return walkImpl(ctx, expr, data, nil, functions)
+	return expression.Eval(ctx, data, functions)
 }
 
-// walkImpl is the actual recursive implementation of the walker
-func walkImpl(ctx context.Context, expr string, data any, indexes []int, functions *Functions) (any, error) {
-	if deadlineMet(ctx) {
-		return nil, errors.New("deadline exceeded")
+// Lookup "walks" the provided data using the provided expression, like Walk, and also tells whether the path exists
+// in the data, which is not the case when a key, field or index along the path is missing
+func Lookup(ctx context.Context, expr string, data any, functions *Functions) (WalkResult, error) {
+	expression, err := Compile(expr)
+	if err != nil {
+		return WalkResult{}, err
 	}
-	if hasCancelled(ctx) {
-		return nil, errors.New("cancelled")
+	return expression.Lookup(ctx, data, functions)
+}
+
+// WalkResult is the result of Lookup
+// Value is the value the expression resolved to
+// Found is false if the path does not exist in the data, as opposed to existing with a nil value
+type WalkResult struct {
+	Value any
+	Found bool
+}
+
+// walkImpl is the actual recursive implementation of the walker. Each invocation applies the first step to the data
+// and recurses with the remaining steps. scope is the data the whole expression is evaluated against, and it's used
+// to resolve the parameters of the functions
+func walkImpl(ctx context.Context, steps []step, data any, scope any, functions *Functions) (any, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
 	}
-	// if data is nil, then check if there's a function to run against it. This generally does not happen, but you
-	// never know someone wants to do something with that nil
-	if data == nil {
-		found, res, err := runFunction(ctx, expr, data, functions)
-		if found || err != nil {
-			return res, err
+	// lazy values are transparent to the walker, as they're resolved when the walker reaches them
+	data, err := resolveLazy(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	// so are pointers, but functions receive the pointer, so that methods with a pointer receiver do not run on a copy
+	receiver := data
+	data = dereference(data)
+	// a variable scope resolves its variable, and anything else is looked up in the outer scope
+	if vars, ok := data.(variableScope); ok {
+		if len(steps) > 0 && steps[0].kind == fieldStep && steps[0].name == vars.name {
+			return walkImpl(ctx, steps[1:], vars.value, scope, functions)
 		}
-		return data, nil
+		return walkImpl(ctx, steps, vars.outer, scope, functions)
 	}
-	if expr == "." {
+	// if there are no steps left, the user wants the data we got as input
+	if len(steps) == 0 {
 		return data, nil
 	}
-	// Let's check the kind of data
-	switch reflect.TypeOf(data).Kind() {
-	// if it's a map...
-	case reflect.Map:
-		t := reflect.ValueOf(data)
-		// if there's no expression left, we can return the data we got as input.
-		// This is the case in which the user wants a whole map returned
-		if len(expr) == 0 {
-			return data, nil
-		}
-		// splitting the current segment from the rest
-		current, next := getSegments(expr)
-		// if we got at least one item, it means we're still selecting
-		if current != "" {
-
-			// If the segment contains one or more indexing blocks for arrays, then we separate the selector and
-			//the indexes. If it doesn't contain indexes, then partial is still the correct selector, and indexes is null
-			partial, indexes := extractIndexes(current)
-
-			if found, res, err := runFunction(ctx, partial, data, functions); err != nil {
-				return res, err
-			} else {
-				if found {
-					return walkImpl(ctx, next, res, indexes, functions)
-				}
+	current := steps[0]
+	// reserved scopes are resolved before anything else
+	switch current.kind {
+	case rootStep:
+		root := scopes(ctx)
+		if root == nil {
+			// when not rendering a template, the root is the data the expression is evaluated against
+			return walkImpl(ctx, steps[1:], scope, scope, functions)
+		}
+		for root.parent != nil {
+			root = root.parent
+		}
+		return walkImpl(ctx, steps[1:], root.data, scope, functions)
+	case parentStep:
+		// each $parent goes one level up in the scope chain
+		chain := scopes(ctx)
+		for len(steps) > 0 && steps[0].kind == parentStep {
+			if chain != nil {
+				chain = chain.parent
 			}
+			steps = steps[1:]
+		}
+		if chain == nil {
+			return walkImpl(ctx, steps, nil, scope, functions)
+		}
+		return walkImpl(ctx, steps, chain.data, scope, functions)
+	}
+	// functions can run against anything, including nil
+	if current.kind == callStep {
+		// only the last function in the expression can write its result to a template output
+		fctx := ctx
+		if len(steps) > 1 {
+			fctx = withoutOutput(ctx)
+		}
+		res, err := runFunction(fctx, current, receiver, scope, functions)
+		if err != nil {
+			return res, stepError(current, err)
+		}
+		return walkImpl(ctx, steps[1:], res, scope, functions)
+	}
+	// if data is nil, whatever we select in it is nil as well, but we keep walking as there may be a function to
+	// run against that nil
+	if data == nil {
+		return walkImpl(ctx, steps[1:], nil, scope, functions)
+	}
+	// custom containers are walked through their own methods, rather than reflection
+	if w, ok := data.(Walkable); ok {
+		return walkWalkable(ctx, steps, w, scope, functions)
+	}
+	switch current.kind {
+	case wildcardStep:
+		items, ok := values(data, functions.jsonTags)
+		if !ok {
+			// there's nothing to project
+			return walkImpl(ctx, steps[1:], nil, scope, functions)
+		}
+		return project(ctx, steps, items, scope, functions)
+	case filterStep:
+		items, ok, err := filter(ctx, current.filter, data, scope, functions)
+		if err != nil {
+			return nil, stepError(current, err)
+		}
+		if !ok {
+			// only arrays can be filtered
+			return walkImpl(ctx, steps[1:], nil, scope, functions)
+		}
+		return project(ctx, steps, items, scope, functions)
+	case descentStep:
+		items, err := descend(ctx, data, current.name, functions.jsonTags, map[uintptr]bool{}, make([]any, 0))
+		if err != nil {
+			return nil, stepError(current, err)
+		}
+		return project(ctx, steps, items, scope, functions)
+	}
+	var res any
+	if current.kind == indexStep {
+		res, err = selectIndex(data, current.index)
+		res = locateMember(res, current, data, receiver)
+	} else if current.kind == keyStep {
+		res, err = selectKey(ctx, current.path, data, scope, functions)
+	} else if current.kind == sliceStep {
+		res = selectRange(data, current.bounds)
+	} else {
+		res, err = selectField(data, current.name, functions.jsonTags)
+		res = locateMember(res, current, data, receiver)
+	}
+	if err != nil {
+		return res, stepError(current, err)
+	}
+	return walkImpl(ctx, steps[1:], res, scope, functions)
+}
 
-			val := t.MapIndex(reflect.ValueOf(partial))
-			if val.IsValid() && !val.IsZero() {
-				// recursion passing the selected value
-				return walkImpl(ctx, next, t.MapIndex(reflect.ValueOf(partial)).Interface(), indexes, functions)
-			} else {
-				return walkImpl(ctx, next, nil, indexes, functions)
-			}
-		} else {
-			// Here we're in the case where current is an empty string...
-			// If it has a next, probably the expression starts with a dot, which is somewhat admissible
-			// pretty much like Go templates work
-			if next != "" {
-				return walkImpl(ctx, next, data, indexes, functions)
-			} else {
-				// otherwise, we return the data itself
-				return data, nil
-			}
+// project applies the steps following a wildcard, a filter or a recursive descent, to each item it selected, and
+// collects the results in a slice. nil results are discarded, and the results of nested projections are flattened. A
+// pipe stage ends the projection, and it applies to the collected results as a whole
+func project(ctx context.Context, steps []step, items []any, scope any, functions *Functions) (any, error) {
+	end := 1
+	for end < len(steps) && !steps[end].pipe {
+		end++
+	}
+	inner := steps[1:end]
+	nested := false
+	for _, st := range inner {
+		nested = nested || st.kind == wildcardStep || st.kind == filterStep || st.kind == descentStep
+	}
+	// the items are collected in the result, so the functions applied to them cannot write to a template output
+	innerCtx := withoutOutput(ctx)
+	res := make([]any, 0, len(items))
+	for _, item := range items {
+		val, err := walkImpl(innerCtx, inner, item, scope, functions)
+		if err != nil {
+			return nil, err
+		}
+		if vals, ok := val.([]any); ok && nested {
+			res = append(res, vals...)
+		} else if val != nil {
+			res = append(res, val)
+		}
+	}
+	return walkImpl(ctx, steps[end:], res, scope, functions)
+}
 
+// descend appends to res the members named `name` found at any depth in data, in document order. Maps are visited in
+// key order. visited holds the maps and pointers being descended into, so that cyclic data structures do not loop
+// forever, while the ones shared by different branches are descended into each time. With tags, struct fields are
+// named after their json tag
+func descend(ctx context.Context, data any, name string, tags bool, visited map[uintptr]bool,
+	res []any) ([]any, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	data, err := resolveLazy(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	if w, ok := data.(Walkable); ok {
+		return descendWalkable(ctx, w, name, tags, visited, res)
+	}
+	val := reflect.ValueOf(data)
+	for val.Kind() == reflect.Pointer || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return res, nil
 		}
-	// if it's a slice...
-	case reflect.Slice:
-		t := reflect.ValueOf(data)
-		if indexes == nil && strings.HasPrefix(expr, "[") {
-			_, indexes = extractIndexes(expr)
-			_, expr = getSegments(expr)
-		}
-		// if there's one or more index selectors
-		if indexes != nil || len(indexes) > 0 {
-			// we pick the first index in the array
-			nextIndex, indexes := sliceOneOff(indexes)
-			// making sure that its value does not exceed the array size
-			if nextIndex < t.Len() {
-				// we select the indexed item and move forward
-				return walkImpl(ctx, expr, t.Index(nextIndex).Interface(), indexes, functions)
-			} else {
-				// if the index exceeds the array size, we return an out-of-bounds error
-				return t, errors.New("index out of bounds")
+		if val.Kind() == reflect.Pointer {
+			if visited[val.Pointer()] {
+				return res, nil
 			}
+			visited[val.Pointer()] = true
+			defer delete(visited, val.Pointer())
 		}
-		// if someone is trying to access a property in an array...
-		if len(expr) > 0 {
-			// we try to understand if it's one fo the available functions, as it's totally legit
-			found, res, err := runFunction(ctx, expr, data, functions)
-			if found || err != nil {
-				return res, err
+		val = val.Elem()
+	}
+	switch val.Kind() {
+	case reflect.Map:
+		if visited[val.Pointer()] {
+			return res, nil
+		}
+		visited[val.Pointer()] = true
+		defer delete(visited, val.Pointer())
+		for _, key := range sortedKeys(val) {
+			item := val.MapIndex(key).Interface()
+			if fmt.Sprint(key.Interface()) == name {
+				res = append(res, item)
+			}
+			if res, err = descend(ctx, item, name, tags, visited, res); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			if res, err = descend(ctx, val.Index(i).Interface(), name, tags, visited, res); err != nil {
+				return nil, err
 			}
-			//... if it's not a function, they're probably doing something wrong
-			return nil, errors.New("cannot access attributes from an array")
 		}
-		// if this has no index, it means the user wants to return the entire array
-		return t.Interface(), nil
 	case reflect.Struct:
-		if expr == "" {
-			return data, nil
+		for _, field := range structFields(val.Type(), tags) {
+			item := val.FieldByIndex(field.index).Interface()
+			// as with findField, fields match by their json tag name and by their Go name
+			if field.name == name || val.Type().FieldByIndex(field.index).Name == name {
+				res = append(res, item)
+			}
+			if res, err = descend(ctx, item, name, tags, visited, res); err != nil {
+				return nil, err
+			}
 		}
-		t := reflect.ValueOf(data)
-		current, next := getSegments(expr)
-
-		// If the segment contains one or more indexing blocks for arrays, then we separate the selector and
-		//the indexes. If it doesn't contain indexes, then partial is still the correct selector, and indexes is null
-		partial, indexes := extractIndexes(current)
+	}
+	return res, nil
+}
 
-		if found, res, err := runFunction(ctx, partial, data, functions); err != nil {
-			return res, err
-		} else {
-			if found {
-				return walkImpl(ctx, next, res, indexes, functions)
+// hasMember tells whether the member selected by a step exists in data
+func hasMember(ctx context.Context, st step, data any, scope any, functions *Functions) (bool, error) {
+	data = dereference(data)
+	if vars, ok := data.(variableScope); ok {
+		if st.kind == fieldStep && st.name == vars.name {
+			return true, nil
+		}
+		return hasMember(ctx, st, vars.outer, scope, functions)
+	}
+	if data == nil {
+		return false, nil
+	}
+	key, err := stepKey(ctx, st, scope, functions)
+	if err != nil {
+		return false, err
+	}
+	if w, ok := data.(Walkable); ok {
+		var found bool
+		if index, isIndex := key.(int); isIndex {
+			if index < 0 {
+				index += w.Len()
+			}
+			if index < 0 || index >= w.Len() {
+				return false, nil
 			}
+			_, found, err = w.WalkIndex(ctx, index)
+		} else {
+			_, found, err = w.WalkField(ctx, fmt.Sprint(key))
 		}
+		return found, err
+	}
+	t := reflect.ValueOf(data)
+	switch t.Kind() {
+	case reflect.Map:
+		k, ok := convertKey(key, t.Type().Key())
+		return ok && t.MapIndex(k).IsValid(), nil
+	case reflect.Slice, reflect.Array:
+		index, ok := key.(int)
+		if index < 0 {
+			index += t.Len()
+		}
+		return ok && index >= 0 && index < t.Len(), nil
+	case reflect.Struct:
+		name, ok := key.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := findField(t.Type(), name, functions.jsonTags)
+		return found, nil
+	}
+	return false, nil
+}
+
+// holder returns the container holding the members of data, which is data itself if it's a map or a slice, and the
+// pointer data was reached through, if any, if it's a struct or an array
+func holder(data any, receiver any) reflect.Value {
+	val := reflect.ValueOf(data)
+	if val.Kind() == reflect.Struct || val.Kind() == reflect.Array {
+		return reflect.ValueOf(receiver)
+	}
+	return val
+}
+
+// locateMember binds res, the member selected by the step st in data, to its location, if it's a lazy function.
+// receiver is the pointer data was reached through, if any
+func locateMember(res any, st step, data any, receiver any) any {
+	// the location is only computed for lazy functions, as most members are not
+	if _, ok := res.(func(context.Context) (any, error)); !ok {
+		return res
+	}
+	if st.kind != indexStep {
+		return locate(res, holder(data, receiver), st.name)
+	}
+	index := st.index
+	if val := reflect.ValueOf(data); index < 0 && (val.Kind() == reflect.Slice || val.Kind() == reflect.Array) {
+		index += val.Len()
+	}
+	return locate(res, holder(data, receiver), index)
+}
 
-		field := t.FieldByName(partial)
+// selectField selects a member named `name` in data. With tags, struct fields can be selected by their json tag name
+func selectField(data any, name string, tags bool) (any, error) {
+	t := reflect.ValueOf(data)
+	// Let's check the kind of data
+	switch t.Kind() {
+	// if it's a map...
+	case reflect.Map:
+		// the name is converted to the key type of the map, and if it can't be, the map does not contain it
+		key, ok := convertKey(name, t.Type().Key())
+		if !ok {
+			return nil, nil
+		}
+		if val := t.MapIndex(key); val.IsValid() {
+			return val.Interface(), nil
+		}
+		return nil, nil
+	// if someone is trying to access a property in an array, they're probably doing something wrong
+	case reflect.Slice, reflect.Array:
+		return nil, ErrArrayAttribute
+	case reflect.Struct:
+		found, ok := findField(t.Type(), name, tags)
+		if !ok {
+			return nil, ErrPrivateField
+		}
+		field, err := t.FieldByIndexErr(found.index)
+		if err != nil {
+			// the field is promoted from a nil embedded struct
+			return nil, nil
+		}
 		if field.Kind() == reflect.Ptr && field.IsNil() {
 			return nil, nil
 		}
 		if field.IsValid() && field.CanInterface() {
-			return walkImpl(ctx, next, field.Interface(), indexes, functions)
-		} else {
-			return nil, errors.New("cannot access private field")
+			return field.Interface(), nil
 		}
-	case reflect.Pointer:
-		t := reflect.ValueOf(data)
-		return walkImpl(ctx, expr, t.Elem().Interface(), indexes, functions)
+		return nil, ErrPrivateField
 	// all other data types
 	default:
-		current, next := getSegments(expr)
+		// if we're still trying to access a resource on a base type, then we're looking for something that does
+		// not exist, so it's nil
+		return nil, nil
+	}
+}
 
-		// If the segment contains one or more indexing blocks for arrays, then we separate the selector and
-		//the indexes. If it doesn't contain indexes, then partial is still the correct selector, and indexes is null
-		partial, indexes := extractIndexes(current)
+// selectIndex selects the item at position `index` in data. Negative indexes count from the end of the array, and maps
+// with numeric keys are looked up by the index
+func selectIndex(data any, index int) (any, error) {
+	t := reflect.ValueOf(data)
+	if t.Kind() == reflect.Map {
+		// maps with numeric keys can be indexed by their keys
+		k, ok := convertKey(index, t.Type().Key())
+		if !ok || !t.MapIndex(k).IsValid() {
+			return nil, nil
+		}
+		return t.MapIndex(k).Interface(), nil
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		// only arrays can be indexed, anything else does not contain what we're looking for
+		return nil, nil
+	}
+	if index < 0 {
+		index += t.Len()
+	}
+	// making sure that the index does not exceed the array size
+	if index < 0 || index >= t.Len() {
+		// if the index exceeds the array size, we return an out-of-bounds error
+		return nil, ErrIndexOutOfBounds
+	}
+	return t.Index(index).Interface(), nil
+}
 
-		// let's check if we need to run a function against it
-		if found, res, err := runFunction(ctx, partial, data, functions); err != nil {
-			return res, err
-		} else {
-			if found {
-				return walkImpl(ctx, next, res, indexes, functions)
-			} else {
-				// if we're still trying to access a resource on a base type, then we're looking for something
-				// that does not exist, so it's nil
-				if len(current) > 0 {
-					return nil, nil
-				}
-			}
+// selectKey selects an item of an array, or a member of a map or struct, by the value of path. The path is evaluated
+// against scope, as function parameters are. Integral values are array indexes, while map keys are converted to the
+// key type of the map
+func selectKey(ctx context.Context, path *Expression, data any, scope any, functions *Functions) (any, error) {
+	key, err := walkImpl(withoutOutput(ctx), path.steps, scope, scope, functions)
+	if err != nil {
+		return nil, err
+	}
+	key = dereference(key)
+	if key == nil {
+		return nil, &KeyError{Path: path.source, Msg: "resolved to nil"}
+	}
+	if w, ok := data.(Walkable); ok {
+		return walkableKey(ctx, w, key)
+	}
+	t := reflect.ValueOf(data)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		index, ok := toInt(key)
+		if !ok {
+			return nil, &KeyError{Path: path.source, Key: key, Msg: "array index is not an integer"}
 		}
-		// otherwise, we just return the value
-		return data, nil
+		return selectIndex(data, index)
+	case reflect.Map:
+		k, ok := convertKey(key, t.Type().Key())
+		if !ok {
+			return nil, &KeyError{Path: path.source, Key: key, Msg: "cannot be converted to " + t.Type().Key().String()}
+		}
+		val := t.MapIndex(k)
+		if val.IsValid() {
+			return val.Interface(), nil
+		}
+		return nil, nil
+	case reflect.Struct:
+		name, ok := key.(string)
+		if !ok {
+			return nil, &KeyError{Path: path.source, Key: key, Msg: "field name is not a string"}
+		}
+		return selectField(data, name, functions.jsonTags)
 	}
+	// anything else does not contain what we're looking for
+	return nil, nil
 }
 
-// getSegments will receive an expression, do a one-split on the dot and return the results.
-// The first returned value is the "current" segment being evaluated, while the second is the "remaining part" of the
-// expression. In absence of a current element or a remaining part, empty strings will be returned
-func getSegments(expr string) (string, string) {
-	mx, err := exprSplitterRegex.FindStringMatch(expr)
-	if err != nil || mx == nil {
-		return expr, ""
+// KeyError is returned when the value of a path in square brackets, as in `items[selected]`, cannot be used as an
+// array index or as a key
+// Path is the path in square brackets
+// Key is the value it resolved to
+// Msg describes why the value cannot be used
+type KeyError struct {
+	Path string
+	Key  any
+	Msg  string
+}
+
+// Error returns a textual representation of the key error
+func (e *KeyError) Error() string {
+	if e.Key == nil {
+		return fmt.Sprintf("invalid key [%s]: %s", e.Path, e.Msg)
 	}
-	idx := mx.Index
-	current := expr[0:idx]
-	next := expr[idx+1:]
-	return current, next
+	return fmt.Sprintf("invalid key [%s] = %v: %s", e.Path, e.Key, e.Msg)
 }
 
-// sliceOneOff will take an array of indexes, take the head off, and return the removed element and the remaining array
-func sliceOneOff(indexes []int) (int, []int) {
-	if len(indexes) == 1 {
-		return indexes[0], nil
+// selectRange selects a range of items in data, as a new slice, also when data is a Go array. Bounds work as in
+// Python: negative bounds count from the end of the array, bounds exceeding the array are clamped, and a negative step
+// walks the array backwards
+func selectRange(data any, bounds sliceBounds) any {
+	t := reflect.ValueOf(data)
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		// only arrays can be sliced, anything else does not contain what we're looking for
+		return nil
+	}
+	size := t.Len()
+	stride := 1
+	if bounds.step != nil {
+		stride = *bounds.step
+	}
+	// clamp turns a bound into a position, defaulting to def when omitted
+	clamp := func(bound *int, def int) int {
+		if bound == nil {
+			return def
+		}
+		pos := *bound
+		if pos < 0 {
+			pos += size
+		}
+		lower, upper := 0, size
+		if stride < 0 {
+			lower, upper = -1, size-1
+		}
+		if pos < lower {
+			return lower
+		}
+		if pos > upper {
+			return upper
+		}
+		return pos
+	}
+	res := reflect.MakeSlice(reflect.SliceOf(t.Type().Elem()), 0, 0)
+	if stride > 0 {
+		for i := clamp(bounds.start, 0); i < clamp(bounds.end, size); i += stride {
+			res = reflect.Append(res, t.Index(i))
+		}
 	} else {
-		return indexes[0], indexes[1:]
-	}
-}
-
-// extractIndexes tries to extract the index from an index notation. Will return the partial expression and an array
-// of indexes as separate return values. If no index was found, then the indexes will be nil. Indexes is an array
-// in case a user is selecting nested arrays, such as array[0][1]
-func extractIndexes(expr string) (string, []int) {
-	// we find the indexing notation blocks
-	bits := indexExtractorRegex.FindAllStringSubmatch(expr, 100)
-	// no indexing notation block?
-	if bits == nil || len(bits) == 0 {
-		// then the expression has no indexing notation. We return the expression and -1
-		return expr, nil
-	}
-	// otherwise, we take care of removing the entire indexing notation from the string. We should be left with
-	// the expression alone
-	partial := indexExtractorRegex.ReplaceAllString(expr, "")
-
-	// converting each found index to an integer and composing the final indexes array
-	indexes := make([]int, 0)
-	for _, bx := range bits {
-		// we discard the error because this is technically impossible to happen as the Regex already captured that
-		// to be an integer. If it wasn't, we wouldn't be here.
-		index, _ := strconv.Atoi(bx[1])
-		indexes = append(indexes, index)
-	}
-	// and return
-	return partial, indexes
+		for i := clamp(bounds.start, size-1); i > clamp(bounds.end, -1); i += stride {
+			res = reflect.Append(res, t.Index(i))
+		}
+	}
+	return res.Interface()
+}
+
+// variableScope is a scope in which a variable named `name` shadows the outer scope, such as the loop variable of an
+// `#each` block
+type variableScope struct {
+	name  string
+	value any
+	outer any
+}
+
+// scopeChain is the chain of scopes of the templates being rendered, from the innermost to the outermost, so that
+// the scopes of the enclosing templates can be referenced as `$parent` and `$root`
+type scopeChain struct {
+	data   any
+	parent *scopeChain
+}
+
+// scopesKey is the context key holding the scope chain
+type scopesKey struct{}
+
+// scopes returns the scope chain of the templates being rendered, or nil if no template is being rendered
+func scopes(ctx context.Context) *scopeChain {
+	if chain, ok := ctx.Value(scopesKey{}).(*scopeChain); ok {
+		return chain
+	}
+	return nil
+}
+
+// withScope returns a context in which data is the innermost scope of the scope chain
+func withScope(ctx context.Context, data any) context.Context {
+	return context.WithValue(ctx, scopesKey{}, &scopeChain{data: data, parent: scopes(ctx)})
+}
+
+// dereference returns the value pointed by data, if data is a pointer. Nil pointers become nil
+func dereference(data any) any {
+	for data != nil && reflect.TypeOf(data).Kind() == reflect.Pointer {
+		t := reflect.ValueOf(data)
+		if t.IsNil() {
+			return nil
+		}
+		// custom containers may implement Walkable with a pointer receiver
+		if _, ok := data.(Walkable); ok {
+			return data
+		}
+		data = t.Elem().Interface()
+	}
+	return data
+}
+
+// checkContext returns an error if the context has been cancelled or its deadline is met
+func checkContext(ctx context.Context) error {
+	if deadlineMet(ctx) {
+		return ErrDeadlineExceeded
+	}
+	if hasCancelled(ctx) {
+		return ErrCancelled
+	}
+	return nil
 }
 
 func deadlineMet(ctx context.Context) bool {